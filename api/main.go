@@ -0,0 +1,36 @@
+// Package main serves the public JSON API so that elastic search does not need to be exposed.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/attwad/cdf/db"
+	"github.com/attwad/cdf/health"
+	"github.com/attwad/cdf/lessons"
+	"github.com/attwad/cdf/search"
+)
+
+var (
+	projectID      = flag.String("project_id", "college-de-france", "Cloud project ID")
+	port           = flag.String("port", "8080", "Port to listen on")
+	elasticAddress = flag.String("elastic_address", "http://elastic:9200", "HTTP address to elastic instance")
+)
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+	d, err := db.NewDatastoreWrapper(ctx, *projectID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/search", search.NewHandler(search.NewElasticSearcher(*elasticAddress)))
+	mux.Handle("/api/lessons", lessons.NewHandler(d, "/api/lessons"))
+	mux.Handle("/api/lessons/", lessons.NewHandler(d, "/api/lessons"))
+	mux.Handle("/healthz", health.NewElasticHealthChecker(*elasticAddress))
+	log.Println("Listening on port", *port)
+	log.Fatal(http.ListenAndServe(":"+*port, mux))
+}
@@ -0,0 +1,100 @@
+// Package artifact names and describes the files stored for each converted course.
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/transcribe"
+	"github.com/attwad/cdf/upload"
+)
+
+// Files are named after the media of the course.
+func base(mediaLink string) string {
+	return filepath.Base(mediaLink)
+}
+
+// TranscriptName is the name of the full transcript of a course.
+func TranscriptName(mediaLink string) string {
+	return base(mediaLink) + ".txt"
+}
+
+// ChunkTranscriptName is the name of the transcript of a single FLAC chunk of a course.
+func ChunkTranscriptName(mediaLink string, chunk int) string {
+	return fmt.Sprintf("%s.chunk-%d.txt", base(mediaLink), chunk)
+}
+
+// ChunkCaptionsName is the name of the captions of a single FLAC chunk of a course, as JSON,
+// they are merged into the subtitles of the course once it is transcribed.
+func ChunkCaptionsName(mediaLink string, chunk int) string {
+	return fmt.Sprintf("%s.chunk-%d.captions.json", base(mediaLink), chunk)
+}
+
+// SubtitleName is the name of the subtitles of a course in the format of the given extension, such as ".vtt".
+func SubtitleName(mediaLink, ext string) string {
+	return base(mediaLink) + ext
+}
+
+// ManifestName is the name of the manifest of a course.
+func ManifestName(mediaLink string) string {
+	return base(mediaLink) + ".json"
+}
+
+// Manifest describes all the files stored for a course.
+type Manifest struct {
+	CourseKey string `json:"course_key"`
+	Source    string `json:"source_url"`
+	MediaLink string `json:"media_link"`
+	// Transcript is the full transcript, the chunk transcripts in order.
+	Transcript string  `json:"transcript"`
+	Chunks     []Chunk `json:"chunks"`
+	// Subtitles are the subtitle files of the whole course, if any.
+	Subtitles []string  `json:"subtitles,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// Chunk is the transcript of a FLAC chunk of the course audio.
+type Chunk struct {
+	Index int `json:"index"`
+	// OffsetSec is when the chunk starts in the course audio.
+	OffsetSec  int    `json:"offset_sec"`
+	Transcript string `json:"transcript"`
+}
+
+// NewManifest describes the files of a course whose audio was transcribed in numChunks chunks.
+func NewManifest(key string, c data.Course, numChunks int, subtitles []string) Manifest {
+	m := Manifest{
+		CourseKey:  key,
+		Source:     c.Source,
+		MediaLink:  c.MediaLink(),
+		Transcript: TranscriptName(c.MediaLink()),
+		Chunks:     make([]Chunk, 0, numChunks),
+		Subtitles:  subtitles,
+		Created:    time.Now().UTC(),
+	}
+	for i := 0; i < numChunks; i++ {
+		m.Chunks = append(m.Chunks, Chunk{
+			Index:      i,
+			OffsetSec:  int((time.Duration(i) * transcribe.ChunkDuration).Seconds()),
+			Transcript: ChunkTranscriptName(c.MediaLink(), i),
+		})
+	}
+	return m
+}
+
+// Upload uploads the manifest as JSON.
+func (m Manifest) Upload(ctx context.Context, u upload.FileUploader) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := u.UploadFile(ctx, bytes.NewReader(b), ManifestName(m.MediaLink)); err != nil {
+		return fmt.Errorf("uploading manifest: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package artifact
+
+import (
+	"testing"
+
+	"github.com/attwad/cdf/data"
+)
+
+func TestNewManifest(t *testing.T) {
+	c := data.Course{Source: "http://a", AudioLink: "http://a/lesson.mp3"}
+	m := NewManifest("k1", c, 2, []string{"lesson.mp3.vtt"})
+	var tests = []struct {
+		msg       string
+		got, want interface{}
+	}{
+		{"transcript", m.Transcript, "lesson.mp3.txt"},
+		{"num chunks", len(m.Chunks), 2},
+		{"first chunk", m.Chunks[0], Chunk{Index: 0, OffsetSec: 0, Transcript: "lesson.mp3.chunk-0.txt"}},
+		{"second chunk", m.Chunks[1], Chunk{Index: 1, OffsetSec: 10790, Transcript: "lesson.mp3.chunk-1.txt"}},
+		{"manifest name", ManifestName(m.MediaLink), "lesson.mp3.json"},
+		{"subtitle name", SubtitleName(m.MediaLink, ".vtt"), "lesson.mp3.vtt"},
+		{"chunk captions name", ChunkCaptionsName(m.MediaLink, 1), "lesson.mp3.chunk-1.captions.json"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("[%s] got=%v, want=%v", test.msg, test.got, test.want)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package data
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 // maxHintChars is the maximum number of characters allowed as a single hint
 // sentence by gspeech api.
 const maxHintChars = 100
 
 // Course represents a lesson, colloque, symposium, etc.
-// It represents a superset of what gets indexed by the search engine (anything that has a json field mapped).
+// What gets indexed by the search engine is built from it, see indexer.Document.
 type Course struct {
 	// Title of the course, "What was at Stake in the India-China Opium Trade?".
 	Title string `json:"title"`
@@ -40,6 +44,8 @@ type Course struct {
 // Entry is what gets stored in Datastore, it contains a course and special storage only fields.
 type Entry struct {
 	Course
+	// Key is the encoded storage key of the entry, filled when reading it but not stored.
+	Key string `datastore:"-" json:"-"`
 	// Whether the course has been converted yet.
 	Converted bool
 	// The hash to "randomly" pick an entry to convert.
@@ -50,6 +56,90 @@ type Entry struct {
 	ScheduledTime time.Time
 	// Transcript is the full text of this lesson.
 	Transcript string `datastore:",noindex" json:"-"`
+	// Progress is how far along the conversion pipeline this course is.
+	Progress Progress `json:"-"`
+	// Retries is how many times the conversion failed.
+	Retries int `json:"-"`
+	// Failed is set when the conversion failed too many times, it will not be scheduled again.
+	Failed bool `json:"-"`
+	// FailureReason is the last error that happened during the conversion.
+	FailureReason string `datastore:",noindex" json:"-"`
+	// LowConfidence are the passages of the transcript the speech recognition was unsure of, to be reviewed.
+	LowConfidence []Span `datastore:",noindex" json:"-"`
+	// NeedsReview is set when the transcript has passages with a low confidence.
+	NeedsReview bool `json:"-"`
+}
+
+// Span is a passage of a transcript.
+type Span struct {
+	// StartSec and EndSec are when it is said from the beginning of the audio.
+	StartSec   int
+	EndSec     int
+	Text       string
+	Confidence float32
+}
+
+// Stage is a step of the conversion pipeline.
+type Stage int
+
+// Stages in order, a course that is not scheduled yet is in StageNone.
+// Stages from StageUploaded to StageIndexed apply to the current FLAC chunk.
+const (
+	StageNone Stage = iota
+	StageDownloaded
+	StageConvertedToFLAC
+	StageUploaded
+	StageSubmitted
+	StageTranscribed
+	StageIndexed
+	StageDone
+)
+
+var stageNames = []string{"none", "downloaded", "converted to flac", "uploaded", "submitted", "transcribed", "indexed", "done"}
+
+func (s Stage) String() string {
+	if s < 0 || int(s) >= len(stageNames) {
+		return fmt.Sprintf("Stage(%d)", s)
+	}
+	return stageNames[s]
+}
+
+// Progress is saved after each step of the conversion so that a restarted worker can resume where it stopped.
+type Progress struct {
+	// Stage is the last completed stage.
+	Stage Stage
+	// AudioPath is the local downloaded audio file, only useful if the worker restarts on the same machine.
+	AudioPath string `datastore:",noindex"`
+	// FLACPaths are the local FLAC chunks, same caveat as AudioPath.
+	FLACPaths []string `datastore:",noindex"`
+	// Chunk is the index in FLACPaths of the chunk being processed.
+	Chunk int `datastore:",noindex"`
+	// OperationName is the speech recognition operation of the current chunk, if it runs as a long running operation.
+	OperationName string `datastore:",noindex"`
+	// OperationStarted is when the operation was started, its deadline runs from then even if the worker restarts.
+	OperationStarted time.Time `datastore:",noindex"`
+	// Local is set when the current chunk is short enough to be transcribed from the local disk without being uploaded.
+	Local bool `datastore:",noindex"`
+	// Sentences is how many sentences of the previous chunks were indexed, the serial of the next one.
+	Sentences int `datastore:",noindex"`
+	// LowConfidence are the passages of the chunks that were already transcribed to be reviewed.
+	LowConfidence []Span `datastore:",noindex"`
+}
+
+// BaseLanguage returns the lower case base of a language code such as "en-US", courses without language are French.
+func BaseLanguage(lang string) string {
+	if lang == "" {
+		return "fr"
+	}
+	return strings.ToLower(strings.SplitN(strings.Replace(lang, "_", "-", -1), "-", 2)[0])
+}
+
+// MediaLink returns the link to download the audio of the course from, its video if there is no audio link.
+func (c *Course) MediaLink() string {
+	if c.AudioLink != "" {
+		return c.AudioLink
+	}
+	return c.VideoLink
 }
 
 // Hints returns a list of sentences or words to help speech recognition.
@@ -45,3 +45,20 @@ func TestHints(t *testing.T) {
 		}
 	}
 }
+
+func TestBaseLanguage(t *testing.T) {
+	var tests = []struct {
+		lang string
+		want string
+	}{
+		{"", "fr"},
+		{"fr", "fr"},
+		{"en-US", "en"},
+		{"EN_gb", "en"},
+	}
+	for _, test := range tests {
+		if got := BaseLanguage(test.lang); got != test.want {
+			t.Errorf("[%s] base language got=%q, want=%q", test.lang, got, test.want)
+		}
+	}
+}
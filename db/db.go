@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"cloud.google.com/go/datastore"
@@ -13,7 +14,24 @@ const pageSize = 15
 
 // Wrapper wraps the datastore for easier testing.
 type Wrapper interface {
+	// GetLessons returns a page of lessons and the cursor of the next page.
+	// Returns a *BadCursorError if the cursor cannot be decoded.
 	GetLessons(ctx context.Context, cursorStr string, filter Filter, size int) ([]data.Entry, string, error)
+	// GetLesson returns the lesson with the given key or ErrNoSuchLesson.
+	GetLesson(ctx context.Context, key string) (data.Entry, error)
+}
+
+// ErrNoSuchLesson is returned when there is no lesson with the requested key.
+var ErrNoSuchLesson = errors.New("no such lesson")
+
+// BadCursorError is returned when a cursor passed to GetLessons is invalid.
+type BadCursorError struct {
+	Cursor string
+	Err    error
+}
+
+func (e *BadCursorError) Error() string {
+	return fmt.Sprintf("bad cursor %q: %v", e.Cursor, e.Err)
 }
 
 type datastoreWrapper struct {
@@ -29,35 +47,33 @@ func NewDatastoreWrapper(ctx context.Context, projectID string) (Wrapper, error)
 	return &datastoreWrapper{client}, nil
 }
 
-// Filter can be passed to GetLessons to filter results.
-type Filter int8
-
-const (
-	// FilterNone will filter nothing.
-	FilterNone Filter = iota
-	// FilterOnlyConverted will return only converted lessons.
-	FilterOnlyConverted
-)
-
 func (d *datastoreWrapper) GetLessons(ctx context.Context, cursorStr string, filter Filter, size int) ([]data.Entry, string, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, "", fmt.Errorf("invalid filter: %v", err)
+	}
 	lessons := make([]data.Entry, 0)
-	query := datastore.NewQuery("Entry").Order("-Scraped").Limit(size)
-	switch filter {
-	case FilterOnlyConverted:
-		query = query.Filter("Converted=", true)
-		break
+	query := newQuery(filter)
+	if !filtersNotReviewed(filter) {
+		query = query.Limit(size)
 	}
 	if cursorStr != "" {
 		cursor, err := datastore.DecodeCursor(cursorStr)
 		if err != nil {
-			return nil, "", fmt.Errorf("bad cursor %q: %v", cursorStr, err)
+			return nil, "", &BadCursorError{cursorStr, err}
 		}
 		query = query.Start(cursor)
 	}
 	it := d.client.Run(ctx, query)
 	for {
+		if len(lessons) == size {
+			nextCursor, err := it.Cursor()
+			if err != nil {
+				return nil, "", fmt.Errorf("getting next cursor: %v", err)
+			}
+			return lessons, nextCursor.String(), nil
+		}
 		var e data.Entry
-		_, err := it.Next(&e)
+		k, err := it.Next(&e)
 		for err == iterator.Done {
 			nextCursor, errc := it.Cursor()
 			if errc != nil {
@@ -68,6 +84,74 @@ func (d *datastoreWrapper) GetLessons(ctx context.Context, cursorStr string, fil
 		if err != nil {
 			return nil, "", fmt.Errorf("failed fetching results: %v", err)
 		}
+		if filtersNotReviewed(filter) && e.NeedsReview {
+			continue
+		}
+		e.Key = k.Encode()
 		lessons = append(lessons, e)
 	}
 }
+
+// filtersNotReviewed tells if the filter asks for the lessons that do not need a review.
+// Entries saved before NeedsReview existed have no such property and would not match NeedsReview = false
+// in a query, so those lessons are filtered in code like the picker does for Failed.
+func filtersNotReviewed(f Filter) bool {
+	return f.NeedsReview != nil && !*f.NeedsReview
+}
+
+// newQuery translates the filter to a query, except for needs_review=false, see filtersNotReviewed.
+// Combining several conditions requires matching composite indexes in Datastore.
+func newQuery(f Filter) *datastore.Query {
+	query := datastore.NewQuery("Entry")
+	for _, c := range []struct{ property, value string }{
+		{"Chaire", f.Chaire},
+		{"Lecturer", f.Lecturer},
+		{"Language", f.Language},
+		{"LessonType", f.LessonType},
+	} {
+		if c.value != "" {
+			query = query.Filter(c.property+" =", c.value)
+		}
+	}
+	if f.Scheduled != nil {
+		query = query.Filter("Scheduled =", *f.Scheduled)
+	}
+	if f.Converted != nil {
+		query = query.Filter("Converted =", *f.Converted)
+	}
+	if f.NeedsReview != nil && *f.NeedsReview {
+		query = query.Filter("NeedsReview =", true)
+	}
+	if !f.From.IsZero() {
+		query = query.Filter("Date >=", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Filter("Date <", f.To)
+	}
+	switch f.Order {
+	case OrderScrapedAsc:
+		return query.Order("Scraped")
+	case OrderDateDesc:
+		return query.Order("-Date")
+	case OrderDateAsc:
+		return query.Order("Date")
+	default:
+		return query.Order("-Scraped")
+	}
+}
+
+func (d *datastoreWrapper) GetLesson(ctx context.Context, key string) (data.Entry, error) {
+	k, err := datastore.DecodeKey(key)
+	if err != nil {
+		return data.Entry{}, ErrNoSuchLesson
+	}
+	var e data.Entry
+	if err := d.client.Get(ctx, k, &e); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return data.Entry{}, ErrNoSuchLesson
+		}
+		return data.Entry{}, fmt.Errorf("client.Get: %v", err)
+	}
+	e.Key = key
+	return e, nil
+}
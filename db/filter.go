@@ -0,0 +1,96 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/attwad/cdf/data"
+)
+
+// Order is the order in which GetLessons returns lessons.
+type Order int8
+
+const (
+	// OrderScrapedDesc returns the most recently scraped lessons first.
+	OrderScrapedDesc Order = iota
+	// OrderScrapedAsc returns the least recently scraped lessons first.
+	OrderScrapedAsc
+	// OrderDateDesc returns the most recent lessons first.
+	OrderDateDesc
+	// OrderDateAsc returns the oldest lessons first.
+	OrderDateAsc
+)
+
+// Filter can be passed to GetLessons to filter results, its zero value filters nothing.
+// All set conditions must match.
+type Filter struct {
+	// Chaire, Lecturer, Language and LessonType must match exactly when not empty.
+	Chaire     string
+	Lecturer   string
+	Language   string
+	LessonType string
+	// From and To restrict the course Date to [From, To) when not zero.
+	// Datastore requires the results to be ordered by date in that case.
+	From time.Time
+	To   time.Time
+	// Scheduled, Converted and NeedsReview must match when not nil.
+	Scheduled   *bool
+	Converted   *bool
+	NeedsReview *bool
+	// Order of the results.
+	Order Order
+}
+
+// Bool returns a pointer to b, for the optional fields of Filter.
+func Bool(b bool) *bool {
+	return &b
+}
+
+// Validate returns an error if the filter cannot be turned into a query.
+func (f Filter) Validate() error {
+	if f.Order < OrderScrapedDesc || f.Order > OrderDateAsc {
+		return fmt.Errorf("unknown order %d", f.Order)
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return fmt.Errorf("empty date range [%s, %s)", f.From, f.To)
+	}
+	if (!f.From.IsZero() || !f.To.IsZero()) && !f.byDate() {
+		return fmt.Errorf("a date range requires ordering by date")
+	}
+	return nil
+}
+
+func (f Filter) byDate() bool {
+	return f.Order == OrderDateDesc || f.Order == OrderDateAsc
+}
+
+// Match returns whether the entry satisfies all conditions of the filter.
+func (f Filter) Match(e *data.Entry) bool {
+	switch {
+	case f.Chaire != "" && e.Chaire != f.Chaire,
+		f.Lecturer != "" && e.Lecturer != f.Lecturer,
+		f.Language != "" && e.Language != f.Language,
+		f.LessonType != "" && e.LessonType != f.LessonType,
+		!f.From.IsZero() && e.Date.Before(f.From),
+		!f.To.IsZero() && !e.Date.Before(f.To),
+		f.Scheduled != nil && e.Scheduled != *f.Scheduled,
+		f.Converted != nil && e.Converted != *f.Converted,
+		f.NeedsReview != nil && e.NeedsReview != *f.NeedsReview:
+		return false
+	}
+	return true
+}
+
+// Less returns whether a comes before b in the order of the filter.
+func (f Filter) Less(a, b *data.Entry) bool {
+	switch f.Order {
+	case OrderScrapedAsc:
+		return a.Scraped.Before(b.Scraped)
+	case OrderDateDesc:
+		return a.Date.After(b.Date)
+	case OrderDateAsc:
+		return a.Date.Before(b.Date)
+	default:
+		return a.Scraped.After(b.Scraped)
+	}
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/attwad/cdf/data"
+)
+
+func TestFilterMatch(t *testing.T) {
+	date := time.Date(2017, 6, 23, 16, 15, 0, 0, time.UTC)
+	e := &data.Entry{
+		Course: data.Course{Chaire: "Chine", Lecturer: "John Doe", Language: "fr", LessonType: "Colloque", Date: date},
+	}
+	var tests = []struct {
+		msg    string
+		filter Filter
+		want   bool
+	}{
+		{"zero filter", Filter{}, true},
+		{"same chaire, unconverted", Filter{Chaire: "Chine", Converted: Bool(false)}, true},
+		{"other chaire", Filter{Chaire: "Inde"}, false},
+		{"other lecturer", Filter{Lecturer: "Jane Doe"}, false},
+		{"other language", Filter{Language: "en"}, false},
+		{"other lesson type", Filter{LessonType: "Cours"}, false},
+		{"converted", Filter{Converted: Bool(true)}, false},
+		{"scheduled", Filter{Scheduled: Bool(true)}, false},
+		{"needs review", Filter{NeedsReview: Bool(true)}, false},
+		{"in date range", Filter{From: date, To: date.AddDate(0, 0, 1)}, true},
+		{"date range is exclusive", Filter{To: date}, false},
+		{"before date range", Filter{From: date.Add(time.Second)}, false},
+	}
+	for _, test := range tests {
+		if got, want := test.filter.Match(e), test.want; got != want {
+			t.Errorf("[%s] match got=%t, want=%t", test.msg, got, want)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	date := time.Date(2017, 6, 23, 0, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		msg     string
+		filter  Filter
+		wantErr bool
+	}{
+		{"zero filter", Filter{}, false},
+		{"date range ordered by date", Filter{From: date, Order: OrderDateAsc}, false},
+		{"date range ordered by scraped", Filter{From: date}, true},
+		{"empty date range", Filter{From: date, To: date, Order: OrderDateDesc}, true},
+		{"unknown order", Filter{Order: 42}, true},
+	}
+	for _, test := range tests {
+		if got, want := test.filter.Validate() != nil, test.wantErr; got != want {
+			t.Errorf("[%s] got error=%t, want=%t", test.msg, got, want)
+		}
+	}
+}
@@ -1,40 +1,61 @@
 package health
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"net/url"
 	"time"
 )
 
-// Checker checks for healthiness of elastic search.
+// Checker checks for healthiness of the search index.
 type Checker interface {
 	http.Handler
 	IsHealthy() bool
 }
 
-type elasticHealthCheck struct {
-	client         *http.Client
-	elasticAddress string
+// httpHealthCheck queries a health endpoint returning a JSON status.
+type httpHealthCheck struct {
+	client  *http.Client
+	address string
+	path    string
+	// healthy are the statuses considered healthy.
+	healthy map[string]bool
 }
 
 // NewElasticHealthChecker returns a new HTTP handler that returns a status 200
 // if elastic search is healthy.
 func NewElasticHealthChecker(elasticAddress string) Checker {
-	return &elasticHealthCheck{
+	return newHTTPHealthChecker(elasticAddress, "_cluster/health", "green", "yellow")
+}
+
+// NewMeilisearchHealthChecker returns a new HTTP handler that returns a status 200
+// if Meilisearch is available.
+func NewMeilisearchHealthChecker(address string) Checker {
+	return newHTTPHealthChecker(address, "health", "available")
+}
+
+func newHTTPHealthChecker(address, path string, healthyStatuses ...string) Checker {
+	h := &httpHealthCheck{
 		client: &http.Client{
 			Timeout: time.Second * 2,
 		},
-		elasticAddress: elasticAddress,
+		address: address,
+		path:    path,
+		healthy: make(map[string]bool),
 	}
+	for _, s := range healthyStatuses {
+		h.healthy[s] = true
+	}
+	return h
 }
 
-func (h *elasticHealthCheck) IsHealthy() bool {
-	u, err := url.Parse(h.elasticAddress)
+func (h *httpHealthCheck) IsHealthy() bool {
+	u, err := url.Parse(h.address)
 	if err != nil {
 		return false
 	}
-	u.Path = "_cluster/health"
+	u.Path = h.path
 	resp, err := h.client.Get(u.String())
 	if err != nil {
 		return false
@@ -47,11 +68,33 @@ func (h *elasticHealthCheck) IsHealthy() bool {
 	if err := json.NewDecoder(resp.Body).Decode(&hr); err != nil {
 		return false
 	}
-	return hr.Status == "green" || hr.Status == "yellow"
+	return h.healthy[hr.Status]
+}
+
+func (h *httpHealthCheck) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(h, w)
+}
+
+type sqlHealthCheck struct {
+	db *sql.DB
+}
+
+// NewSQLHealthChecker returns a new HTTP handler that returns a status 200
+// if the database can be reached.
+func NewSQLHealthChecker(db *sql.DB) Checker {
+	return &sqlHealthCheck{db}
+}
+
+func (h *sqlHealthCheck) IsHealthy() bool {
+	return h.db.Ping() == nil
+}
+
+func (h *sqlHealthCheck) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(h, w)
 }
 
-func (h *elasticHealthCheck) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !h.IsHealthy() {
+func serve(c Checker, w http.ResponseWriter) {
+	if !c.IsHealthy() {
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
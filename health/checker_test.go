@@ -42,3 +42,26 @@ func TestHealthCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestMeilisearchHealthCheck(t *testing.T) {
+	var tests = []struct {
+		msg      string
+		jsonResp string
+		want     bool
+	}{
+		{"available", `{"status":"available"}`, true},
+		{"other status", `{"status":"green"}`, false},
+	}
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got, want := r.URL.Path, "/health"; got != want {
+				t.Errorf("[%s] path got=%q, want=%q", test.msg, got, want)
+			}
+			io.WriteString(w, test.jsonResp)
+		}))
+		defer ts.Close()
+		if got, want := NewMeilisearchHealthChecker(ts.URL).IsHealthy(), test.want; got != want {
+			t.Errorf("[%s] healthy got=%t, want=%t", test.msg, got, want)
+		}
+	}
+}
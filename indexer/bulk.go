@@ -0,0 +1,104 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxBulkItems and maxBulkBytes limit the size of a single bulk request.
+	maxBulkItems = 500
+	maxBulkBytes = 5 << 20
+	// maxBulkAttempts is how many times a bulk request or an item failing temporarily is sent.
+	maxBulkAttempts    = 5
+	initialBulkBackoff = 500 * time.Millisecond
+)
+
+// ItemError is the result of indexing a single sentence, returned in a BulkError if it failed.
+type ItemError struct {
+	// Serial of the sentence.
+	Serial int
+	// Status is the HTTP status of the item.
+	Status int
+	// Reason is why it failed, if it did.
+	Reason string
+}
+
+func (e ItemError) Error() string {
+	return fmt.Sprintf("sentence %d: status %d: %s", e.Serial, e.Status, e.Reason)
+}
+
+// BulkError is returned by Index when some sentences could not be indexed, the others were.
+type BulkError struct {
+	Items []ItemError
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("%d sentences could not be indexed, first one: %v", len(e.Items), e.Items[0])
+}
+
+// retryableError is a failure of a whole bulk request that may succeed if sent again.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// bulkItem is the action and document lines of a sentence.
+type bulkItem struct {
+	serial int
+	lines  string
+}
+
+// splitBulk splits items in batches of at most maxItems and maxBytes, a single bigger item gets its own batch.
+func splitBulk(items []bulkItem, maxItems, maxBytes int) [][]bulkItem {
+	batches := make([][]bulkItem, 0)
+	start, size := 0, 0
+	for j, item := range items {
+		if j > start && (j-start == maxItems || size+len(item.lines) > maxBytes) {
+			batches = append(batches, items[start:j])
+			start, size = j, 0
+		}
+		size += len(item.lines)
+	}
+	if start < len(items) {
+		batches = append(batches, items[start:])
+	}
+	return batches
+}
+
+func hasFailure(results []ItemError) bool {
+	for _, r := range results {
+		if r.Status >= 300 {
+			return true
+		}
+	}
+	return false
+}
+
+// errorReason returns the reason of a bulk item error, which is an object or a string depending on the version.
+func errorReason(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var e struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(raw, &e); err == nil {
+		return e.Type + ": " + e.Reason
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
@@ -0,0 +1,137 @@
+package indexer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/attwad/cdf/data"
+)
+
+func TestSplitBulk(t *testing.T) {
+	items := make([]bulkItem, 0)
+	for _, size := range []int{3, 3, 3, 10, 1, 1} {
+		items = append(items, bulkItem{serial: len(items), lines: strings.Repeat("x", size)})
+	}
+	var tests = []struct {
+		msg      string
+		maxItems int
+		maxBytes int
+		want     string
+	}{
+		{"one batch", 10, 100, "[[0 1 2 3 4 5]]"},
+		{"by count", 4, 100, "[[0 1 2 3] [4 5]]"},
+		{"by size", 10, 7, "[[0 1] [2] [3] [4 5]]"},
+	}
+	for _, test := range tests {
+		batches := make([][]int, 0)
+		for _, b := range splitBulk(items, test.maxItems, test.maxBytes) {
+			serials := make([]int, 0)
+			for _, item := range b {
+				serials = append(serials, item.serial)
+			}
+			batches = append(batches, serials)
+		}
+		if got, want := fmt.Sprint(batches), test.want; got != want {
+			t.Errorf("[%s] batches got=%s, want=%s", test.msg, got, want)
+		}
+	}
+}
+
+// bulkResponder answers successive bulk requests with the given status codes and bodies, and records the requests.
+type bulkResponder struct {
+	mu        sync.Mutex
+	statuses  []int
+	responses []string
+	requests  []string
+}
+
+func (b *bulkResponder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(b.requests)
+	b.requests = append(b.requests, string(body))
+	if n >= len(b.responses) {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(b.statuses[n])
+	io.WriteString(w, b.responses[n])
+}
+
+func newTestBulkIndexer(host string) *elasticIndexer {
+	i := newElasticIndexer(host, Alias, "").(*elasticIndexer)
+	i.sleep = func(time.Duration) {}
+	return i
+}
+
+func TestIndexRetries(t *testing.T) {
+	b := &bulkResponder{
+		statuses: []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK},
+		responses: []string{
+			`{"error":"unavailable"}`,
+			`{"took":1,"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}]}`,
+			`{"took":1,"errors":false,"items":[{"index":{"status":201}}]}`,
+		},
+	}
+	ts := httptest.NewServer(b)
+	defer ts.Close()
+	i := newTestBulkIndexer(ts.URL)
+	if err := i.Index("k", data.Course{Source: "http://a"}, []Sentence{{Serial: 0, Text: "first"}, {Serial: 1, Text: "second"}}); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if got, want := len(b.requests), 3; got != want {
+		t.Fatalf("num requests got=%d, want=%d", got, want)
+	}
+	// Only the item that failed temporarily is sent again.
+	if last := b.requests[2]; strings.Contains(last, "first") || !strings.Contains(last, "second") {
+		t.Errorf("retried request got=%s, want only the second sentence", last)
+	}
+}
+
+func TestIndexItemErrors(t *testing.T) {
+	b := &bulkResponder{
+		statuses: []int{http.StatusOK},
+		responses: []string{
+			`{"took":1,"errors":true,"items":[{"index":{"status":201}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse [date]"}}}]}`,
+		},
+	}
+	ts := httptest.NewServer(b)
+	defer ts.Close()
+	i := newTestBulkIndexer(ts.URL)
+	err := i.Index("k", data.Course{Source: "http://a"}, []Sentence{{Serial: 4, Text: "first"}, {Serial: 5, Text: "second"}})
+	be, ok := err.(*BulkError)
+	if !ok {
+		t.Fatalf("Index error got=%v, want a *BulkError", err)
+	}
+	if got, want := fmt.Sprint(be.Items), "[sentence 5: status 400: mapper_parsing_exception: failed to parse [date]]"; got != want {
+		t.Errorf("failed items got=%s, want=%s", got, want)
+	}
+	if got, want := len(b.requests), 1; got != want {
+		t.Errorf("num requests got=%d, want=%d", got, want)
+	}
+}
+
+func TestIndexGivesUp(t *testing.T) {
+	b := &bulkResponder{}
+	for j := 0; j < maxBulkAttempts; j++ {
+		b.statuses = append(b.statuses, http.StatusTooManyRequests)
+		b.responses = append(b.responses, `{"error":"too many requests"}`)
+	}
+	ts := httptest.NewServer(b)
+	defer ts.Close()
+	i := newTestBulkIndexer(ts.URL)
+	if err := i.Index("k", data.Course{}, []Sentence{{Text: "first"}}); err == nil {
+		t.Error("wanted error but got nil")
+	}
+	if got, want := len(b.requests), maxBulkAttempts; got != want {
+		t.Errorf("num requests got=%d, want=%d", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"time"
+
+	"github.com/attwad/cdf/data"
+)
+
+// Document is what is indexed for each sentence of a course.
+// Its fields do not depend on how courses are stored.
+type Document struct {
+	// CourseKey is the storage key of the course.
+	CourseKey   string     `json:"course_key"`
+	Title       string     `json:"title"`
+	Lecturer    string     `json:"lecturer"`
+	Function    string     `json:"function"`
+	Date        *time.Time `json:"date,omitempty"`
+	LessonType  string     `json:"lesson_type,omitempty"`
+	TypeTitle   string     `json:"type_title,omitempty"`
+	Chaire      string     `json:"chaire"`
+	Language    string     `json:"lang,omitempty"`
+	Source      string     `json:"source_url"`
+	AudioLink   string     `json:"audio_link,omitempty"`
+	VideoLink   string     `json:"video_link,omitempty"`
+	DurationSec int        `json:"duration_sec,omitempty"`
+	// Serial is the position of the sentence in the course, capitalized like in documents indexed before this type.
+	Serial     int    `json:"Serial"`
+	Transcript string `json:"transcript"`
+	// TranscriptFR and TranscriptEN repeat the transcript in the field analyzed for the language of the course, if supported.
+	TranscriptFR string `json:"transcript_fr,omitempty"`
+	TranscriptEN string `json:"transcript_en,omitempty"`
+	// StartSec and EndSec are when the sentence is said in the audio.
+	StartSec int `json:"start_sec"`
+	EndSec   int `json:"end_sec,omitempty"`
+	// Confidence of the speech recognition of the sentence, between 0 and 1, omitted if unknown.
+	Confidence float32 `json:"confidence,omitempty"`
+	// Speaker of the sentence when the course has several, omitted if unknown.
+	Speaker int `json:"speaker,omitempty"`
+}
+
+// newDocuments returns the documents to index for the sentences of a course.
+func newDocuments(key string, c data.Course, sentences []Sentence) []Document {
+	var date *time.Time
+	if !c.Date.IsZero() {
+		d := c.Date
+		date = &d
+	}
+	lang := data.BaseLanguage(c.Language)
+	docs := make([]Document, 0, len(sentences))
+	for _, sentence := range sentences {
+		d := Document{
+			CourseKey:   key,
+			Title:       c.Title,
+			Lecturer:    c.Lecturer,
+			Function:    c.Function,
+			Date:        date,
+			LessonType:  c.LessonType,
+			TypeTitle:   c.TypeTitle,
+			Chaire:      c.Chaire,
+			Language:    c.Language,
+			Source:      c.Source,
+			AudioLink:   c.AudioLink,
+			VideoLink:   c.VideoLink,
+			DurationSec: c.DurationSec,
+			Serial:      sentence.Serial,
+			Transcript:  sentence.Text,
+			StartSec:    int(sentence.Start.Seconds()),
+			EndSec:      int(sentence.End.Seconds()),
+			Confidence:  sentence.Confidence,
+			Speaker:     sentence.Speaker,
+		}
+		switch lang {
+		case "fr":
+			d.TranscriptFR = sentence.Text
+		case "en":
+			d.TranscriptEN = sentence.Text
+		}
+		docs = append(docs, d)
+	}
+	return docs
+}
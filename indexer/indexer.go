@@ -1,34 +1,77 @@
 package indexer
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/attwad/cdf/data"
 )
 
 // Indexer handles indexing of a course's transcript.
+// Courses are identified by their Source.
 type Indexer interface {
-	Index(data.Course, []string) error
+	// Index adds the sentences of the course with the given storage key to the index, indexing no sentences does nothing.
+	// A sentence indexed again with the same Serial replaces the previous one.
+	Index(key string, c data.Course, sentences []Sentence) error
+	// Delete removes all the sentences of a course from the index.
+	Delete(data.Course) error
+}
+
+// Sentence is a piece of a transcript to be indexed.
+type Sentence struct {
+	// Serial is the position of the sentence in the whole course, it identifies the sentence in the index.
+	Serial int
+	Text   string
+	// Start and End are when the sentence starts and ends from the beginning of the course audio.
+	Start time.Duration
+	End   time.Duration
+	// Confidence is how sure the speech recognition is of Text, between 0 and 1, 0 if unknown.
+	Confidence float32
+	// Speaker of the sentence when the course has several, starting at 1, 0 if unknown.
+	Speaker int
+}
+
+// docID returns the ID of the document of a sentence, the same every time it is indexed.
+func docID(c data.Course, serial int) string {
+	return fmt.Sprintf("%x-%d", sha1.Sum([]byte(c.Source)), serial)
 }
 
 type elasticIndexer struct {
 	client *http.Client
 	host   string
+	// index or alias the documents are added to.
+	index string
+	// docType is the mapping type of the documents, empty for versions without mapping types.
+	docType string
+	// sleep waits between retries.
+	sleep func(time.Duration)
 }
 
-// NewElasticIndexer creates a new Indexer connected to elastic search.
+// NewElasticIndexer creates a new Indexer connected to elastic search 5.x.
 func NewElasticIndexer(host string) Indexer {
+	return newElasticIndexer(host, Alias, "transcript")
+}
+
+// NewOpenSearchIndexer creates a new Indexer connected to OpenSearch or elastic search 8.x, which have no mapping types.
+func NewOpenSearchIndexer(host string) Indexer {
+	return newElasticIndexer(host, Alias, "")
+}
+
+func newElasticIndexer(host, index, docType string) Indexer {
 	return &elasticIndexer{
 		client: &http.Client{
-			Timeout: time.Second * 10,
+			Timeout: time.Second * 30,
 		},
-		host: host,
+		host:    host,
+		index:   index,
+		docType: docType,
+		sleep:   time.Sleep,
 	}
 }
 
@@ -38,40 +81,101 @@ type entry struct {
 
 type indexEntry struct {
 	Index string `json:"_index"`
-	Type  string `json:"_type"`
-}
-
-type transcript struct {
-	data.Course
-	Serial     int
-	Transcript string `json:"transcript"`
+	ID    string `json:"_id"`
+	Type  string `json:"_type,omitempty"`
 }
 
-func (i *elasticIndexer) Index(c data.Course, sentences []string) error {
-	js := make([]string, 0)
-	e := entry{Index: indexEntry{Index: "course", Type: "transcript"}}
-	eb, err := json.Marshal(e)
-	if err != nil {
-		return err
+func (i *elasticIndexer) Index(key string, c data.Course, sentences []Sentence) error {
+	if len(sentences) == 0 {
+		return nil
 	}
-	seb := string(eb)
-	for i, sentence := range sentences {
-		jt := transcript{Course: c, Transcript: sentence, Serial: i}
-		b, err2 := json.Marshal(jt)
-		if err2 != nil {
+	items := make([]bulkItem, 0, len(sentences))
+	for _, jt := range newDocuments(key, c, sentences) {
+		e := entry{Index: indexEntry{Index: i.index, ID: docID(c, jt.Serial), Type: i.docType}}
+		eb, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(jt)
+		if err != nil {
 			return err
 		}
-		js = append(js, seb, string(b))
+		items = append(items, bulkItem{serial: jt.Serial, lines: string(eb) + "\n" + string(b) + "\n"})
+	}
+	failed := make([]ItemError, 0)
+	for _, batch := range splitBulk(items, maxBulkItems, maxBulkBytes) {
+		itemErrs, err := i.sendWithRetries(batch)
+		if err != nil {
+			return err
+		}
+		failed = append(failed, itemErrs...)
+	}
+	if len(failed) > 0 {
+		return &BulkError{Items: failed}
+	}
+	return nil
+}
+
+// sendWithRetries sends a batch, retrying the whole request or only the items that failed temporarily, with backoff.
+// Items that still fail are returned, the error is for failures of the whole request.
+func (i *elasticIndexer) sendWithRetries(batch []bulkItem) ([]ItemError, error) {
+	failed := make([]ItemError, 0)
+	backoff := initialBulkBackoff
+	for attempt := 1; ; attempt++ {
+		results, err := i.send(batch)
+		lastAttempt := attempt == maxBulkAttempts
+		if _, retryable := err.(*retryableError); err != nil && (!retryable || lastAttempt) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("Bulk request failed (attempt %d/%d), retrying in %s: %v", attempt, maxBulkAttempts, backoff, err)
+		} else {
+			retry := make([]bulkItem, 0)
+			for j, r := range results {
+				switch {
+				case r.Status < 300:
+				case retryableStatus(r.Status) && !lastAttempt:
+					retry = append(retry, batch[j])
+				default:
+					failed = append(failed, r)
+				}
+			}
+			if len(retry) == 0 {
+				return failed, nil
+			}
+			log.Printf("%d/%d sentences failed temporarily (attempt %d/%d), retrying them in %s", len(retry), len(batch), attempt, maxBulkAttempts, backoff)
+			batch = retry
+		}
+		i.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send sends a single bulk request and returns the result of each item, in order.
+func (i *elasticIndexer) send(batch []bulkItem) ([]ItemError, error) {
+	var body bytes.Buffer
+	for _, item := range batch {
+		body.WriteString(item.lines)
 	}
-	r := strings.NewReader(strings.Join(js, "\n") + "\n")
-	resp, err := i.client.Post(i.host+"/_bulk", "application/json", r)
+	resp, err := i.client.Post(i.host+"/_bulk", "application/x-ndjson", &body)
 	if err != nil {
-		return err
+		return nil, &retryableError{err}
 	}
 	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &retryableError{fmt.Errorf("reading response body: %v", err)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("bulk request failed with status %s: %s", resp.Status, respBody)
+		if retryableStatus(resp.StatusCode) {
+			return nil, &retryableError{err}
+		}
+		return nil, err
+	}
 	type index struct {
-		Status int    `json:"status"`
-		Error  string `json:"error"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
 	}
 	type item struct {
 		Index index `json:"index"`
@@ -83,21 +187,59 @@ func (i *elasticIndexer) Index(c data.Course, sentences []string) error {
 		HasError bool   `json:"errors"`
 		Items    []item `json:"items"`
 	}
+	var ir indexResp
+	if err := json.Unmarshal(respBody, &ir); err != nil {
+		return nil, fmt.Errorf("unmarshall response body: %v", err)
+	}
+	log.Printf("Indexed %d sentences in %dms, errors: %t", len(ir.Items), ir.TookMs, ir.HasError)
+	if len(ir.Items) != len(batch) {
+		return nil, fmt.Errorf("got %d results for %d sentences: %s", len(ir.Items), len(batch), respBody)
+	}
+	results := make([]ItemError, 0, len(batch))
+	for j, it := range ir.Items {
+		results = append(results, ItemError{
+			Serial: batch[j].serial,
+			Status: it.Index.Status,
+			Reason: errorReason(it.Index.Error),
+		})
+	}
+	if ir.HasError && !hasFailure(results) {
+		return nil, fmt.Errorf("indexing response had an error: %s", respBody)
+	}
+	return results, nil
+}
+
+func (i *elasticIndexer) Delete(c data.Course) error {
+	b, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"source_url.keyword": c.Source},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := i.client.Post(i.host+"/"+i.index+"/_delete_by_query?conflicts=proceed", "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("reading response body: %v", err)
 	}
-	var ir indexResp
-	if err := json.Unmarshal(respBody, &ir); err != nil {
-		return fmt.Errorf("unmarshall response body: %v", err)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("deleting failed with status %s: %s", resp.Status, respBody)
+	}
+	var dr struct {
+		Deleted  int               `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
 	}
-	log.Printf("Indexing response: %+v", ir)
-	if ir.HasError {
-		log.Println("Indexing response had an error:", string(respBody))
-		return fmt.Errorf("indexing response had an error")
+	if err := json.Unmarshal(respBody, &dr); err != nil {
+		return fmt.Errorf("unmarshall response body: %v", err)
 	}
-	if len(ir.Items) == 0 {
-		return fmt.Errorf("nothing was indexed")
+	if len(dr.Failures) > 0 {
+		return fmt.Errorf("deleting had %d failures: %s", len(dr.Failures), respBody)
 	}
+	log.Printf("Deleted %d sentences of %s", dr.Deleted, c.Source)
 	return nil
 }
@@ -1,19 +1,27 @@
 package indexer
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/attwad/cdf/data"
 )
 
 func TestIndex(t *testing.T) {
 	title := "A lesson"
-	sentences := []string{"sentence 1", "sentence 2"}
+	sentences := []Sentence{{Text: "sentence 1"}, {Text: "sentence 2", Start: 3 * time.Second}}
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if _, err := io.WriteString(w, `{"took":11,"errors":false,"items":[{"index":{"_index":"course","_type":"transcript","_id":"AV2O2EyhLu53oBP8SQm_","_version":1,"result":"created","_shards":{"total":2,"successful":1,"failed":0},"created":true,"status":201}},{"index":{"_index":"course","_type":"transcript","_id":"AV2O2EyhLu53oBP8SQnA","_version":1,"result":"created","_shards":{"total":2,"successful":1,"failed":0},"created":true,"status":201}}]}`); err != nil {
 			t.Fatalf("Could not send test response %v", err)
@@ -29,15 +37,18 @@ func TestIndex(t *testing.T) {
 			t.Errorf("Missing %q in request sent to server", title)
 		}
 		for _, sentence := range sentences {
-			if !strings.Contains(s, sentence) {
-				t.Errorf("Missing %q in request sent to server", sentence)
+			if !strings.Contains(s, sentence.Text) {
+				t.Errorf("Missing %q in request sent to server", sentence.Text)
 			}
 		}
+		if !strings.Contains(s, `"start_sec":3`) {
+			t.Error("Missing start offset in request sent to server")
+		}
 	}))
 	defer ts.Close()
 
 	i := NewElasticIndexer(ts.URL)
-	if err := i.Index(data.Course{Title: title}, sentences); err != nil {
+	if err := i.Index("k", data.Course{Title: title}, sentences); err != nil {
 		t.Errorf("Indexing course: %v", err)
 	}
 }
@@ -64,9 +75,272 @@ func TestIndexFails(t *testing.T) {
 		defer ts.Close()
 
 		i := NewElasticIndexer(ts.URL)
-		err := i.Index(data.Course{Title: "a title"}, []string{"sentence 1"})
+		err := i.Index("k", data.Course{Title: "a title"}, []Sentence{{Text: "sentence 1"}})
 		if err == nil {
 			t.Errorf("[%s] Wanted indexing error but got nil", test.msg)
 		}
 	}
 }
+
+// testBackend creates an Indexer and a function returning the documents it indexed so far, in order.
+type testBackend struct {
+	name  string
+	setUp func(t *testing.T) (i Indexer, indexed func() []Document, tearDown func())
+}
+
+// testBackends are run through the same behaviour tests, backends needing build tags add themselves from init.
+var testBackends = []testBackend{
+	{"elastic", func(t *testing.T) (Indexer, func() []Document, func()) {
+		return setUpFakeElastic(t, NewElasticIndexer, true)
+	}},
+	{"opensearch", func(t *testing.T) (Indexer, func() []Document, func()) {
+		return setUpFakeElastic(t, NewOpenSearchIndexer, false)
+	}},
+	{"meilisearch", setUpFakeMeilisearch},
+}
+
+// fakeServer keeps the documents sent to it by id.
+type fakeServer struct {
+	mu   sync.Mutex
+	ids  []string
+	docs map[string]Document
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{docs: make(map[string]Document)}
+}
+
+func (f *fakeServer) put(id string, doc Document) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.docs[id]; !ok {
+		f.ids = append(f.ids, id)
+	}
+	f.docs[id] = doc
+}
+
+// deleteSource deletes the documents of a course and returns how many were deleted.
+func (f *fakeServer) deleteSource(source string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0)
+	for _, id := range f.ids {
+		if f.docs[id].Source == source {
+			delete(f.docs, id)
+		} else {
+			ids = append(ids, id)
+		}
+	}
+	n := len(f.ids) - len(ids)
+	f.ids = ids
+	return n
+}
+
+func (f *fakeServer) indexed() []Document {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	docs := make([]Document, 0, len(f.ids))
+	for _, id := range f.ids {
+		docs = append(docs, f.docs[id])
+	}
+	return docs
+}
+
+func setUpFakeElastic(t *testing.T, newIndexer func(string) Indexer, wantType bool) (Indexer, func() []Document, func()) {
+	f := newFakeServer()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/course/_delete_by_query" {
+			var q struct {
+				Query struct {
+					Term map[string]string `json:"term"`
+				} `json:"query"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+				t.Errorf("decoding delete query: %v", err)
+				return
+			}
+			fmt.Fprintf(w, `{"deleted":%d,"failures":[]}`, f.deleteSource(q.Query.Term["source_url.keyword"]))
+			return
+		}
+		items := make([]string, 0)
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var e entry
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				t.Errorf("decoding action: %v", err)
+				return
+			}
+			if got, want := e.Index.Type != "", wantType; got != want {
+				t.Errorf("action %s has type got=%t, want=%t", sc.Text(), got, want)
+			}
+			if e.Index.ID == "" {
+				t.Errorf("action %s has no id", sc.Text())
+			}
+			if !sc.Scan() {
+				t.Error("action without document")
+				return
+			}
+			var doc Document
+			if err := json.Unmarshal(sc.Bytes(), &doc); err != nil {
+				t.Errorf("decoding document: %v", err)
+				return
+			}
+			f.put(e.Index.ID, doc)
+			items = append(items, `{"index":{"_index":"course","status":201}}`)
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	return newIndexer(ts.URL), f.indexed, ts.Close
+}
+
+func setUpFakeMeilisearch(t *testing.T) (Indexer, func() []Document, func()) {
+	f := newFakeServer()
+	numSettings := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer key"; got != want {
+			t.Errorf("authorization header got=%q, want=%q", got, want)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /indexes/course/documents":
+			var docs []meiliDocument
+			if err := json.NewDecoder(r.Body).Decode(&docs); err != nil {
+				t.Errorf("decoding documents: %v", err)
+				return
+			}
+			for _, d := range docs {
+				if d.ID == "" {
+					t.Errorf("document %+v has no id", d)
+				}
+				f.put(d.ID, d.Document)
+			}
+		case "PATCH /indexes/course/settings":
+			if numSettings++; numSettings > 1 {
+				t.Errorf("settings updated %d times, want once", numSettings)
+			}
+		case "POST /indexes/course/documents/delete":
+			if numSettings == 0 {
+				t.Error("deleting documents before source_url is filterable")
+			}
+			var d struct {
+				Filter string `json:"filter"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+				t.Errorf("decoding deletion: %v", err)
+				return
+			}
+			source, err := strconv.Unquote(strings.TrimPrefix(d.Filter, "source_url = "))
+			if err != nil {
+				t.Errorf("unexpected filter %q: %v", d.Filter, err)
+				return
+			}
+			f.deleteSource(source)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, `{"taskUid":1,"status":"enqueued"}`)
+	}))
+	i, err := NewMeilisearchIndexer(ts.URL, "key")
+	if err != nil {
+		t.Fatalf("NewMeilisearchIndexer: %v", err)
+	}
+	return i, f.indexed, ts.Close
+}
+
+func TestBackends(t *testing.T) {
+	date := time.Date(2017, 6, 23, 16, 15, 0, 0, time.UTC)
+	c := data.Course{
+		Title:       "A lesson",
+		Lecturer:    "John Doe",
+		Chaire:      "Chine",
+		Language:    "fr",
+		Source:      "http://a",
+		Date:        date,
+		AudioLink:   "http://a.mp3",
+		VideoLink:   "http://a.mp4",
+		DurationSec: 4000,
+	}
+	other := data.Course{Title: "Another lesson", Source: "http://b"}
+	chunks := [][]Sentence{
+		{{Serial: 0, Text: "sentence 1", End: 3 * time.Second}, {Serial: 1, Text: "sentence 2", Start: 3 * time.Second, End: 5 * time.Second}},
+		{},
+		{{Serial: 2, Text: "sentence 3", Start: time.Hour, End: time.Hour + time.Second, Confidence: 0.5, Speaker: 2}},
+		// Indexed again as after a crash.
+		{{Serial: 0, Text: "sentence 1", End: 3 * time.Second}, {Serial: 1, Text: "sentence 2", Start: 3 * time.Second, End: 5 * time.Second}},
+	}
+	doc := func(serial int, text string, start, end int) Document {
+		return Document{
+			CourseKey:   "k1",
+			Title:       "A lesson",
+			Lecturer:    "John Doe",
+			Chaire:      "Chine",
+			Language:    "fr",
+			Source:      "http://a",
+			Date:        &date,
+			AudioLink:   "http://a.mp3",
+			VideoLink:   "http://a.mp4",
+			DurationSec: 4000,
+			Serial:      serial,
+			Transcript:  text,
+			StartSec:    start,
+			EndSec:      end,
+		}
+	}
+	want := []Document{
+		doc(0, "sentence 1", 0, 3),
+		doc(1, "sentence 2", 3, 5),
+		doc(2, "sentence 3", 3600, 3601),
+	}
+	want[2].Confidence, want[2].Speaker = 0.5, 2
+	for _, b := range testBackends {
+		i, indexed, tearDown := b.setUp(t)
+		for _, sentences := range chunks {
+			if err := i.Index("k1", c, sentences); err != nil {
+				t.Errorf("[%s] Index: %v", b.name, err)
+			}
+		}
+		if err := i.Index("k2", other, []Sentence{{Text: "other"}}); err != nil {
+			t.Errorf("[%s] Index: %v", b.name, err)
+		}
+		if err := i.Delete(other); err != nil {
+			t.Errorf("[%s] Delete: %v", b.name, err)
+		}
+		got := indexed()
+		sort.Slice(got, func(i, j int) bool {
+			return got[i].Serial < got[j].Serial
+		})
+		// Not every backend has fields analyzed per language.
+		for i := range got {
+			got[i].TranscriptFR, got[i].TranscriptEN = "", ""
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("[%s] indexed got=%+v, want=%+v", b.name, got, want)
+		}
+		tearDown()
+	}
+}
+
+func TestNewDocumentsLanguage(t *testing.T) {
+	var tests = []struct {
+		lang   string
+		wantFR string
+		wantEN string
+	}{
+		{"", "hello", ""},
+		{"fr", "hello", ""},
+		{"en-US", "", "hello"},
+		{"EN", "", "hello"},
+		{"de", "", ""},
+	}
+	for _, test := range tests {
+		ts := newDocuments("k", data.Course{Language: test.lang}, []Sentence{{Text: "hello"}})
+		if got, want := ts[0].TranscriptFR, test.wantFR; got != want {
+			t.Errorf("[%s] transcript_fr got=%q, want=%q", test.lang, got, want)
+		}
+		if got, want := ts[0].TranscriptEN, test.wantEN; got != want {
+			t.Errorf("[%s] transcript_en got=%q, want=%q", test.lang, got, want)
+		}
+	}
+}
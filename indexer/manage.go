@@ -0,0 +1,341 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// Alias is the name under which the current version of the index is searched and written to.
+	Alias = "course"
+	// FirstVersion is the first version created by a Manager, version 1 being the index created manually as Alias.
+	FirstVersion = 2
+)
+
+// IndexName returns the name of the given version of the index.
+func IndexName(version int) string {
+	return fmt.Sprintf("%s-v%d", Alias, version)
+}
+
+// Manager manages the versions of the index behind the alias and the template they are created from.
+type Manager interface {
+	// EnsureIndex updates the template and, if there is no index yet, creates the first version behind the alias.
+	EnsureIndex() error
+	// CurrentVersion returns the version the alias points to, 1 if Alias is an index, 0 if there is none.
+	CurrentVersion() (int, error)
+	// CreateVersion updates the template and creates an empty index of the given version.
+	CreateVersion(version int) error
+	// Indexer returns an Indexer adding documents to the given version of the index.
+	Indexer(version int) Indexer
+	// SwapAlias atomically points the alias to the given version.
+	// An index named Alias, created before versions existed, is deleted in the same operation.
+	SwapAlias(version int) error
+	// Copy copies the documents of a version, 1 being the index named Alias, to another, except those of the courses
+	// with the excluded sources and those already in the destination.
+	// Only documents with a course key are copied, older ones do not have the shape of the current documents.
+	Copy(from, to int, exclude []string) error
+}
+
+// copyPollInterval is how often a Copy checks whether the copy is complete.
+const copyPollInterval = 5 * time.Second
+
+type elasticManager struct {
+	client  *http.Client
+	host    string
+	docType string
+	// sleep waits between checks of a copy.
+	sleep func(time.Duration)
+}
+
+// NewElasticManager creates a new Manager for elastic search 5.x.
+func NewElasticManager(host string) Manager {
+	return newElasticManager(host, "transcript")
+}
+
+// NewOpenSearchManager creates a new Manager for OpenSearch or elastic search 8.x, which use composable templates.
+func NewOpenSearchManager(host string) Manager {
+	return newElasticManager(host, "")
+}
+
+func newElasticManager(host, docType string) Manager {
+	return &elasticManager{
+		client: &http.Client{
+			Timeout: time.Second * 30,
+		},
+		host:    host,
+		docType: docType,
+		sleep:   time.Sleep,
+	}
+}
+
+// versionName returns the name of the index of the given version, the index named Alias for version 1.
+func versionName(version int) string {
+	if version == 1 {
+		return Alias
+	}
+	return IndexName(version)
+}
+
+// textWithKeyword is a text field that can also be filtered, sorted and aggregated on with its .keyword sub field.
+var textWithKeyword = map[string]interface{}{
+	"type": "text",
+	"fields": map[string]interface{}{
+		"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256},
+	},
+}
+
+// mapping returns the mapping of transcript documents.
+func mapping() map[string]interface{} {
+	text := map[string]interface{}{"type": "text"}
+	link := map[string]interface{}{"type": "keyword", "index": false}
+	return map[string]interface{}{
+		"properties": map[string]interface{}{
+			"course_key":    map[string]interface{}{"type": "keyword"},
+			"audio_link":    link,
+			"video_link":    link,
+			"duration_sec":  map[string]interface{}{"type": "integer"},
+			"title":         text,
+			"function":      text,
+			"type_title":    text,
+			"lecturer":      textWithKeyword,
+			"chaire":        textWithKeyword,
+			"lesson_type":   textWithKeyword,
+			"lang":          textWithKeyword,
+			"source_url":    textWithKeyword,
+			"transcript":    text,
+			"transcript_fr": map[string]interface{}{"type": "text", "analyzer": "french"},
+			"transcript_en": map[string]interface{}{"type": "text", "analyzer": "english"},
+			"Serial":        map[string]interface{}{"type": "integer"},
+			"start_sec":     map[string]interface{}{"type": "integer"},
+			"end_sec":       map[string]interface{}{"type": "integer"},
+			"confidence":    map[string]interface{}{"type": "float"},
+			"speaker":       map[string]interface{}{"type": "integer"},
+			"date":          map[string]interface{}{"type": "date"},
+		},
+	}
+}
+
+func (m *elasticManager) putTemplate() error {
+	pattern := Alias + "-v*"
+	if m.docType != "" {
+		return m.do("PUT", "/_template/"+Alias, map[string]interface{}{
+			"template": pattern,
+			"mappings": map[string]interface{}{m.docType: mapping()},
+		}, nil)
+	}
+	return m.do("PUT", "/_index_template/"+Alias, map[string]interface{}{
+		"index_patterns": []string{pattern},
+		"template": map[string]interface{}{
+			"mappings": mapping(),
+		},
+	}, nil)
+}
+
+func (m *elasticManager) EnsureIndex() error {
+	if err := m.putTemplate(); err != nil {
+		return fmt.Errorf("putting template: %v", err)
+	}
+	exists, err := m.exists("/" + Alias)
+	if err != nil || exists {
+		return err
+	}
+	return m.do("PUT", "/"+IndexName(FirstVersion), map[string]interface{}{
+		"aliases": map[string]interface{}{Alias: map[string]interface{}{}},
+	}, nil)
+}
+
+// aliasedIndices returns the indices the alias points to.
+func (m *elasticManager) aliasedIndices() ([]string, error) {
+	exists, err := m.exists("/_alias/" + Alias)
+	if err != nil || !exists {
+		return nil, err
+	}
+	var resp map[string]interface{}
+	if err := m.do("GET", "/_alias/"+Alias, nil, &resp); err != nil {
+		return nil, err
+	}
+	indices := make([]string, 0, len(resp))
+	for index := range resp {
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+func (m *elasticManager) CurrentVersion() (int, error) {
+	indices, err := m.aliasedIndices()
+	if err != nil {
+		return 0, err
+	}
+	if len(indices) == 0 {
+		exists, err := m.exists("/" + Alias)
+		if err != nil || !exists {
+			return 0, err
+		}
+		return 1, nil
+	}
+	current := 0
+	for _, index := range indices {
+		var v int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(index, Alias+"-v"), "%d", &v); err != nil {
+			return 0, fmt.Errorf("alias points to unversioned index %q", index)
+		}
+		if v > current {
+			current = v
+		}
+	}
+	return current, nil
+}
+
+func (m *elasticManager) CreateVersion(version int) error {
+	if err := m.putTemplate(); err != nil {
+		return fmt.Errorf("putting template: %v", err)
+	}
+	return m.do("PUT", "/"+IndexName(version), map[string]interface{}{}, nil)
+}
+
+func (m *elasticManager) Indexer(version int) Indexer {
+	return newElasticIndexer(m.host, IndexName(version), m.docType)
+}
+
+func (m *elasticManager) SwapAlias(version int) error {
+	indices, err := m.aliasedIndices()
+	if err != nil {
+		return err
+	}
+	actions := make([]interface{}, 0)
+	if len(indices) == 0 {
+		legacy, err := m.exists("/" + Alias)
+		if err != nil {
+			return err
+		}
+		if legacy {
+			actions = append(actions, map[string]interface{}{
+				"remove_index": map[string]interface{}{"index": Alias},
+			})
+		}
+	}
+	name := IndexName(version)
+	for _, index := range indices {
+		if index != name {
+			actions = append(actions, map[string]interface{}{
+				"remove": map[string]interface{}{"index": index, "alias": Alias},
+			})
+		}
+	}
+	actions = append(actions, map[string]interface{}{
+		"add": map[string]interface{}{"index": name, "alias": Alias},
+	})
+	return m.do("POST", "/_aliases", map[string]interface{}{"actions": actions}, nil)
+}
+
+func (m *elasticManager) Copy(from, to int, exclude []string) error {
+	query := map[string]interface{}{
+		"filter": map[string]interface{}{
+			"exists": map[string]interface{}{"field": "course_key"},
+		},
+	}
+	if len(exclude) > 0 {
+		query["must_not"] = map[string]interface{}{
+			"terms": map[string]interface{}{"source_url.keyword": exclude},
+		}
+	}
+	// Copies of whole indices take longer than the client timeout, the task is run in the background and checked on.
+	var started struct {
+		Task string `json:"task"`
+	}
+	if err := m.do("POST", "/_reindex?wait_for_completion=false", map[string]interface{}{
+		"conflicts": "proceed",
+		"source": map[string]interface{}{
+			"index": versionName(from),
+			"query": map[string]interface{}{"bool": query},
+		},
+		"dest": map[string]interface{}{
+			"index":   versionName(to),
+			"op_type": "create",
+		},
+	}, &started); err != nil {
+		return err
+	}
+	for {
+		var task struct {
+			Completed bool `json:"completed"`
+			Response  struct {
+				Created  int               `json:"created"`
+				Failures []json.RawMessage `json:"failures"`
+			} `json:"response"`
+			Error json.RawMessage `json:"error"`
+		}
+		if err := m.do("GET", "/_tasks/"+started.Task, nil, &task); err != nil {
+			return err
+		}
+		switch {
+		case task.Error != nil:
+			return fmt.Errorf("copying %s to %s failed: %s", versionName(from), versionName(to), task.Error)
+		case len(task.Response.Failures) > 0:
+			return fmt.Errorf("copying %s to %s had %d failures: %s", versionName(from), versionName(to), len(task.Response.Failures), task.Response.Failures[0])
+		case task.Completed:
+			log.Printf("Copied %d documents from %s to %s", task.Response.Created, versionName(from), versionName(to))
+			return nil
+		}
+		m.sleep(copyPollInterval)
+	}
+}
+
+// exists returns whether HEAD on the path succeeds.
+func (m *elasticManager) exists(path string) (bool, error) {
+	resp, err := m.client.Head(m.host + path)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("HEAD %s returned %s", path, resp.Status)
+}
+
+// do sends the JSON body if not nil and decodes the response in v if not nil.
+func (m *elasticManager) do(method, path string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, m.host+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, respBody)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, v); err != nil {
+		return fmt.Errorf("unmarshall response body: %v", err)
+	}
+	return nil
+}
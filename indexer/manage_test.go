@@ -0,0 +1,197 @@
+package indexer
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/attwad/cdf/data"
+)
+
+// fakeCluster answers the requests of a Manager from a map of "METHOD /path" to response body, 404 if missing,
+// and records them.
+type fakeCluster struct {
+	mu        sync.Mutex
+	responses map[string]string
+	requests  []string
+}
+
+func (f *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req := r.Method + " " + r.URL.Path
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, strings.TrimSpace(req+" "+string(b)))
+	resp, ok := f.responses[req]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	io.WriteString(w, resp)
+}
+
+func (f *fakeCluster) requested(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reqs := make([]string, 0)
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, prefix) {
+			reqs = append(reqs, r)
+		}
+	}
+	return reqs
+}
+
+func TestEnsureIndex(t *testing.T) {
+	var tests = []struct {
+		msg         string
+		newManager  func(string) Manager
+		responses   map[string]string
+		wantCreated bool
+		wantTmpl    string
+	}{
+		{
+			msg:        "no index yet",
+			newManager: NewElasticManager,
+			responses: map[string]string{
+				"PUT /_template/course": `{}`,
+				"PUT /course-v2":        `{}`,
+			},
+			wantCreated: true,
+			wantTmpl:    `"mappings":{"transcript":`,
+		}, {
+			msg:        "existing index",
+			newManager: NewOpenSearchManager,
+			responses: map[string]string{
+				"PUT /_index_template/course": `{}`,
+				"HEAD /course":                ``,
+			},
+			wantTmpl: `"index_patterns":["course-v*"]`,
+		},
+	}
+	for _, test := range tests {
+		f := &fakeCluster{responses: test.responses}
+		ts := httptest.NewServer(f)
+		if err := test.newManager(ts.URL).EnsureIndex(); err != nil {
+			t.Errorf("[%s] EnsureIndex: %v", test.msg, err)
+		}
+		tmpl := f.requested("PUT /_")
+		if len(tmpl) != 1 || !strings.Contains(tmpl[0], test.wantTmpl) || !strings.Contains(tmpl[0], `"analyzer":"french"`) {
+			t.Errorf("[%s] template requests got=%v, want one containing %s", test.msg, tmpl, test.wantTmpl)
+		}
+		created := f.requested("PUT /course-v2")
+		if got, want := len(created) == 1, test.wantCreated; got != want {
+			t.Errorf("[%s] created index got=%t, want=%t", test.msg, got, want)
+		}
+		if test.wantCreated && !strings.Contains(created[0], `"aliases":{"course":{}}`) {
+			t.Errorf("[%s] index created without alias: %s", test.msg, created[0])
+		}
+		ts.Close()
+	}
+}
+
+func TestSwapAlias(t *testing.T) {
+	var tests = []struct {
+		msg         string
+		responses   map[string]string
+		wantVersion int
+		wantActions string
+	}{
+		{
+			msg: "from previous version",
+			responses: map[string]string{
+				"HEAD /_alias/course": ``,
+				"GET /_alias/course":  `{"course-v2":{"aliases":{"course":{}}}}`,
+				"POST /_aliases":      `{"acknowledged":true}`,
+			},
+			wantVersion: 2,
+			wantActions: `{"actions":[{"remove":{"alias":"course","index":"course-v2"}},{"add":{"alias":"course","index":"course-v3"}}]}`,
+		}, {
+			msg: "from manually created index",
+			responses: map[string]string{
+				"HEAD /course":   ``,
+				"POST /_aliases": `{"acknowledged":true}`,
+			},
+			wantVersion: 1,
+			wantActions: `{"actions":[{"remove_index":{"index":"course"}},{"add":{"alias":"course","index":"course-v3"}}]}`,
+		},
+	}
+	for _, test := range tests {
+		f := &fakeCluster{responses: test.responses}
+		ts := httptest.NewServer(f)
+		m := NewElasticManager(ts.URL)
+		v, err := m.CurrentVersion()
+		if err != nil {
+			t.Errorf("[%s] CurrentVersion: %v", test.msg, err)
+		}
+		if got, want := v, test.wantVersion; got != want {
+			t.Errorf("[%s] current version got=%d, want=%d", test.msg, got, want)
+		}
+		if err := m.SwapAlias(3); err != nil {
+			t.Errorf("[%s] SwapAlias: %v", test.msg, err)
+		}
+		if got, want := f.requested("POST /_aliases"), []string{"POST /_aliases " + test.wantActions}; len(got) != 1 || got[0] != want[0] {
+			t.Errorf("[%s] alias requests got=%v, want=%v", test.msg, got, want)
+		}
+		ts.Close()
+	}
+}
+
+func TestManagerIndexer(t *testing.T) {
+	f := &fakeCluster{responses: map[string]string{
+		"POST /_bulk": `{"took":1,"errors":false,"items":[{"index":{"status":201}}]}`,
+	}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+	if err := NewOpenSearchManager(ts.URL).Indexer(3).Index("k", data.Course{}, []Sentence{{Text: "hello"}}); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if got := f.requested("POST /_bulk"); len(got) != 1 || !strings.Contains(got[0], `{"index":{"_index":"course-v3",`) {
+		t.Errorf("bulk requests got=%v, want one to course-v3", got)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	f := &fakeCluster{responses: map[string]string{
+		"POST /_reindex":     `{"task":"node:1"}`,
+		"GET /_tasks/node:1": `{"completed":true,"response":{"created":2,"failures":[]}}`,
+	}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+	m := NewElasticManager(ts.URL)
+	if err := m.Copy(1, 3, []string{"http://a"}); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	got := f.requested("POST /_reindex")
+	if len(got) != 1 {
+		t.Fatalf("reindex requests got=%v, want one", got)
+	}
+	for _, want := range []string{`"index":"course"`, `"index":"course-v3","op_type":"create"`, `"exists":{"field":"course_key"}`, `"source_url.keyword":["http://a"]`} {
+		if !strings.Contains(got[0], want) {
+			t.Errorf("reindex request got=%s, want containing %s", got[0], want)
+		}
+	}
+}
+
+func TestCopyFailures(t *testing.T) {
+	f := &fakeCluster{responses: map[string]string{
+		"POST /_reindex":     `{"task":"node:1"}`,
+		"GET /_tasks/node:1": `{"completed":true,"response":{"created":1,"failures":[{"id":"x"}]}}`,
+	}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+	if err := NewOpenSearchManager(ts.URL).Copy(2, 3, nil); err == nil {
+		t.Error("Copy with failures got no error")
+	}
+	if got := f.requested("POST /_reindex"); len(got) != 1 || strings.Contains(got[0], "must_not") {
+		t.Errorf("reindex requests got=%v, want one without exclusions", got)
+	}
+}
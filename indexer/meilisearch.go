@@ -0,0 +1,93 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/attwad/cdf/data"
+)
+
+type meilisearchIndexer struct {
+	client *http.Client
+	host   string
+	apiKey string
+}
+
+// NewMeilisearchIndexer creates a new Indexer that adds documents to the "course" index of a Meilisearch instance.
+// apiKey can be empty if the instance is not protected.
+// Meilisearch indexes documents asynchronously, so failures after the documents are accepted are not reported.
+// The index is made filterable by source, which deleting the sentences of a course needs.
+func NewMeilisearchIndexer(host, apiKey string) (Indexer, error) {
+	i := &meilisearchIndexer{
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+		host:   host,
+		apiKey: apiKey,
+	}
+	// Tasks run in order, so source_url is filterable by the time any deletion runs.
+	if err := i.do("PATCH", "/indexes/course/settings", map[string]interface{}{
+		"filterableAttributes": []string{"source_url"},
+	}); err != nil {
+		return nil, fmt.Errorf("updating index settings: %v", err)
+	}
+	return i, nil
+}
+
+// meiliDocument is a Document with the primary key Meilisearch requires.
+type meiliDocument struct {
+	ID string `json:"id"`
+	Document
+}
+
+func (i *meilisearchIndexer) Index(key string, c data.Course, sentences []Sentence) error {
+	if len(sentences) == 0 {
+		return nil
+	}
+	docs := make([]meiliDocument, 0, len(sentences))
+	for _, d := range newDocuments(key, c, sentences) {
+		docs = append(docs, meiliDocument{ID: docID(c, d.Serial), Document: d})
+	}
+	return i.do("POST", "/indexes/course/documents?primaryKey=id", docs)
+}
+
+func (i *meilisearchIndexer) Delete(c data.Course) error {
+	return i.do("POST", "/indexes/course/documents/delete", map[string]interface{}{
+		"filter": fmt.Sprintf("source_url = %q", c.Source),
+	})
+}
+
+// do sends the JSON body to the path and returns an error if the task is not accepted.
+func (i *meilisearchIndexer) do(method, path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, i.host+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if i.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+i.apiKey)
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("meilisearch returned %s: %s", resp.Status, respBody)
+	}
+	log.Println("Meilisearch response:", string(respBody))
+	return nil
+}
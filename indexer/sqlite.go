@@ -0,0 +1,114 @@
+package indexer
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/attwad/cdf/data"
+)
+
+// Documents are kept in a regular table, where a sentence indexed again replaces the previous one,
+// and the FTS5 index of their text is kept in sync with it by triggers.
+// The date is stored in RFC 3339 format, empty if unknown.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS documents (
+	id INTEGER PRIMARY KEY,
+	transcript TEXT, title TEXT, lecturer TEXT, function TEXT, chaire TEXT, type_title TEXT,
+	course_key TEXT, date TEXT, lesson_type TEXT, lang TEXT, source_url TEXT,
+	audio_link TEXT, video_link TEXT, duration_sec INTEGER, serial INTEGER, start_sec INTEGER, end_sec INTEGER,
+	confidence REAL, speaker INTEGER,
+	UNIQUE(source_url, serial)
+);
+CREATE VIRTUAL TABLE IF NOT EXISTS transcripts USING fts5(
+	transcript, title, lecturer, function, chaire, type_title,
+	content = 'documents', content_rowid = 'id',
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+CREATE TRIGGER IF NOT EXISTS documents_ai AFTER INSERT ON documents BEGIN
+	INSERT INTO transcripts (rowid, transcript, title, lecturer, function, chaire, type_title)
+	VALUES (new.id, new.transcript, new.title, new.lecturer, new.function, new.chaire, new.type_title);
+END;
+CREATE TRIGGER IF NOT EXISTS documents_ad AFTER DELETE ON documents BEGIN
+	INSERT INTO transcripts (transcripts, rowid, transcript, title, lecturer, function, chaire, type_title)
+	VALUES ('delete', old.id, old.transcript, old.title, old.lecturer, old.function, old.chaire, old.type_title);
+END;
+CREATE TRIGGER IF NOT EXISTS documents_au AFTER UPDATE ON documents BEGIN
+	INSERT INTO transcripts (transcripts, rowid, transcript, title, lecturer, function, chaire, type_title)
+	VALUES ('delete', old.id, old.transcript, old.title, old.lecturer, old.function, old.chaire, old.type_title);
+	INSERT INTO transcripts (rowid, transcript, title, lecturer, function, chaire, type_title)
+	VALUES (new.id, new.transcript, new.title, new.lecturer, new.function, new.chaire, new.type_title);
+END`
+
+// sqliteColumns are the columns of the documents table in the order of sqliteValues.
+const sqliteColumns = `transcript, title, lecturer, function, chaire, type_title, course_key, date, lesson_type, lang, source_url,
+	audio_link, video_link, duration_sec, serial, start_sec, end_sec, confidence, speaker`
+
+// sqliteUpdates replaces the columns of a sentence indexed again.
+var sqliteUpdates = func() string {
+	var sets []string
+	for _, c := range strings.Split(sqliteColumns, ",") {
+		c = strings.TrimSpace(c)
+		sets = append(sets, c+" = excluded."+c)
+	}
+	return strings.Join(sets, ", ")
+}()
+
+func sqliteValues(d Document) []interface{} {
+	date := ""
+	if d.Date != nil {
+		date = d.Date.Format(time.RFC3339)
+	}
+	return []interface{}{
+		d.Transcript, d.Title, d.Lecturer, d.Function, d.Chaire, d.TypeTitle, d.CourseKey, date, d.LessonType, d.Language, d.Source,
+		d.AudioLink, d.VideoLink, d.DurationSec, d.Serial, d.StartSec, d.EndSec, d.Confidence, d.Speaker,
+	}
+}
+
+type sqliteIndexer struct {
+	db *sql.DB
+}
+
+// NewSQLiteIndexer creates a new Indexer that stores transcripts in the documents table of the given database,
+// searchable through the transcripts FTS5 table, creating them if needed.
+// The driver must be built with FTS5 enabled, github.com/mattn/go-sqlite3 needs the sqlite_fts5 build tag.
+func NewSQLiteIndexer(db *sql.DB) (Indexer, error) {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return nil, fmt.Errorf("creating transcripts table: %v", err)
+	}
+	return &sqliteIndexer{db}, nil
+}
+
+func (i *sqliteIndexer) Index(key string, c data.Course, sentences []Sentence) error {
+	if len(sentences) == 0 {
+		return nil
+	}
+	tx, err := i.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO documents (` + sqliteColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (source_url, serial) DO UPDATE SET ` + sqliteUpdates)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("preparing insert: %v", err)
+	}
+	defer stmt.Close()
+	for _, d := range newDocuments(key, c, sentences) {
+		if _, err := stmt.Exec(sqliteValues(d)...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("inserting sentence %d: %v", d.Serial, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+	return nil
+}
+
+func (i *sqliteIndexer) Delete(c data.Course) error {
+	if _, err := i.db.Exec(`DELETE FROM documents WHERE source_url = ?`, c.Source); err != nil {
+		return fmt.Errorf("deleting sentences: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+//go:build sqlite_fts5
+// +build sqlite_fts5
+
+package indexer
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/attwad/cdf/data"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	testBackends = append(testBackends, testBackend{"sqlite", setUpSQLite})
+}
+
+func setUpSQLite(t *testing.T) (Indexer, func() []Document, func()) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	// Each connection to :memory: is a different database.
+	db.SetMaxOpenConns(1)
+	i, err := NewSQLiteIndexer(db)
+	if err != nil {
+		t.Fatalf("NewSQLiteIndexer: %v", err)
+	}
+	indexed := func() []Document {
+		rows, err := db.Query(`SELECT ` + sqliteColumns + ` FROM documents ORDER BY id`)
+		if err != nil {
+			t.Fatalf("querying transcripts: %v", err)
+		}
+		defer rows.Close()
+		docs := make([]Document, 0)
+		for rows.Next() {
+			var d Document
+			var date string
+			if err := rows.Scan(
+				&d.Transcript, &d.Title, &d.Lecturer, &d.Function, &d.Chaire, &d.TypeTitle, &d.CourseKey, &date, &d.LessonType, &d.Language, &d.Source,
+				&d.AudioLink, &d.VideoLink, &d.DurationSec, &d.Serial, &d.StartSec, &d.EndSec, &d.Confidence, &d.Speaker,
+			); err != nil {
+				t.Fatalf("scanning transcript: %v", err)
+			}
+			if date != "" {
+				parsed, err := time.Parse(time.RFC3339, date)
+				if err != nil {
+					t.Fatalf("parsing date %q: %v", date, err)
+				}
+				d.Date = &parsed
+			}
+			docs = append(docs, d)
+		}
+		return docs
+	}
+	return i, indexed, func() { db.Close() }
+}
+
+func TestSQLiteMatch(t *testing.T) {
+	i, _, tearDown := setUpSQLite(t)
+	defer tearDown()
+	if err := i.Index("k", data.Course{Title: "A lesson"}, []Sentence{{Text: "Le commerce de l'opium"}, {Serial: 1, Text: "autre chose"}}); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	var text string
+	if err := i.(*sqliteIndexer).db.QueryRow("SELECT transcript FROM transcripts WHERE transcript MATCH ?", "opium").Scan(&text); err != nil {
+		t.Fatalf("matching: %v", err)
+	}
+	if got, want := text, "Le commerce de l'opium"; got != want {
+		t.Errorf("matched got=%q, want=%q", got, want)
+	}
+}
+
+func TestSQLiteMatchReindexed(t *testing.T) {
+	i, _, tearDown := setUpSQLite(t)
+	defer tearDown()
+	c := data.Course{Source: "s", Title: "A lesson"}
+	if err := i.Index("k", c, []Sentence{{Text: "Le commerce de l'opium"}}); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if err := i.Index("k", c, []Sentence{{Text: "Le commerce du thé"}}); err != nil {
+		t.Fatalf("Index again: %v", err)
+	}
+	var n int
+	if err := i.(*sqliteIndexer).db.QueryRow("SELECT COUNT(*) FROM transcripts WHERE transcripts MATCH ?", "opium").Scan(&n); err != nil {
+		t.Fatalf("matching: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("matches of the replaced sentence got=%d, want=0", n)
+	}
+	if err := i.(*sqliteIndexer).db.QueryRow("SELECT COUNT(*) FROM transcripts WHERE transcripts MATCH ?", "the").Scan(&n); err != nil {
+		t.Fatalf("matching: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("matches of the new sentence got=%d, want=1", n)
+	}
+}
@@ -0,0 +1,199 @@
+// Package lessons serves the lessons stored in the database as JSON.
+package lessons
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/db"
+)
+
+const (
+	defaultSize = 15
+	maxSize     = 100
+)
+
+// lesson is the JSON representation of an entry, with the fields the course JSON omits.
+type lesson struct {
+	data.Course
+	Key         string    `json:"key"`
+	Date        time.Time `json:"date"`
+	VideoLink   string    `json:"video_link,omitempty"`
+	AudioLink   string    `json:"audio_link"`
+	DurationSec int       `json:"duration_sec"`
+	Converted   bool      `json:"converted"`
+	NeedsReview bool      `json:"needs_review"`
+	Transcript  string    `json:"transcript,omitempty"`
+	// LowConfidence are the passages of the transcript to review.
+	LowConfidence []span `json:"low_confidence,omitempty"`
+}
+
+type span struct {
+	StartSec   int     `json:"start_sec"`
+	EndSec     int     `json:"end_sec"`
+	Text       string  `json:"text"`
+	Confidence float32 `json:"confidence"`
+}
+
+func newLesson(e data.Entry, withTranscript bool) lesson {
+	l := lesson{
+		Course:      e.Course,
+		Key:         e.Key,
+		Date:        e.Date,
+		VideoLink:   e.VideoLink,
+		AudioLink:   e.AudioLink,
+		DurationSec: e.DurationSec,
+		Converted:   e.Converted,
+		NeedsReview: e.NeedsReview,
+	}
+	if withTranscript {
+		l.Transcript = e.Transcript
+		for _, s := range e.LowConfidence {
+			l.LowConfidence = append(l.LowConfidence, span{s.StartSec, s.EndSec, s.Text, s.Confidence})
+		}
+	}
+	return l
+}
+
+type listResponse struct {
+	Lessons    []lesson `json:"lessons"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+type handler struct {
+	db     db.Wrapper
+	prefix string
+}
+
+// NewHandler returns an HTTP handler serving lessons under the given path prefix, for example "/api/lessons".
+// The prefix itself lists lessons, with the URL parameters cursor, size, chaire, lecturer, lang, lesson_type,
+// from and to (2006-01-02), scheduled, converted and needs_review (0 or 1) and order (-scraped, scraped, -date or date).
+// prefix/{key} returns a single lesson including its transcript.
+func NewHandler(d db.Wrapper, prefix string) http.Handler {
+	return &handler{d, strings.TrimSuffix(prefix, "/")}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.Trim(strings.TrimPrefix(r.URL.Path, h.prefix), "/")
+	if key == "" {
+		h.list(w, r)
+		return
+	}
+	h.get(w, r, key)
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	filter, err := parseFilter(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	size := defaultSize
+	if s := v.Get("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxSize {
+			http.Error(w, fmt.Sprintf("bad size parameter %q, must be in [1, %d]", s, maxSize), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	entries, next, err := h.db.GetLessons(r.Context(), v.Get("cursor"), filter, size)
+	if err != nil {
+		if _, ok := err.(*db.BadCursorError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Println("Getting lessons:", err)
+		http.Error(w, "getting lessons failed", http.StatusInternalServerError)
+		return
+	}
+	resp := listResponse{
+		Lessons:    make([]lesson, 0, len(entries)),
+		NextCursor: next,
+	}
+	for _, e := range entries {
+		resp.Lessons = append(resp.Lessons, newLesson(e, false))
+	}
+	writeJSON(w, resp)
+}
+
+var orders = map[string]db.Order{
+	"":         db.OrderScrapedDesc,
+	"-scraped": db.OrderScrapedDesc,
+	"scraped":  db.OrderScrapedAsc,
+	"-date":    db.OrderDateDesc,
+	"date":     db.OrderDateAsc,
+}
+
+func parseFilter(v url.Values) (db.Filter, error) {
+	f := db.Filter{
+		Chaire:     v.Get("chaire"),
+		Lecturer:   v.Get("lecturer"),
+		Language:   v.Get("lang"),
+		LessonType: v.Get("lesson_type"),
+	}
+	order, ok := orders[v.Get("order")]
+	if !ok {
+		return f, fmt.Errorf("bad order parameter %q", v.Get("order"))
+	}
+	f.Order = order
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if s := v.Get(p.name); s != "" {
+			t, err := time.Parse("2006-01-02", s)
+			if err != nil {
+				return f, fmt.Errorf("bad %s parameter %q, must be a date like 2006-01-02", p.name, s)
+			}
+			*p.t = t
+		}
+	}
+	for _, p := range []struct {
+		name string
+		b    **bool
+	}{{"scheduled", &f.Scheduled}, {"converted", &f.Converted}, {"needs_review", &f.NeedsReview}} {
+		switch s := v.Get(p.name); s {
+		case "":
+		case "0":
+			*p.b = db.Bool(false)
+		case "1":
+			*p.b = db.Bool(true)
+		default:
+			return f, fmt.Errorf("bad %s parameter %q, must be 0 or 1", p.name, s)
+		}
+	}
+	if err := f.Validate(); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request, key string) {
+	e, err := h.db.GetLesson(r.Context(), key)
+	if err == db.ErrNoSuchLesson {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println("Getting lesson:", err)
+		http.Error(w, "getting lesson failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, newLesson(e, true))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Encoding response:", err)
+	}
+}
@@ -0,0 +1,144 @@
+package lessons
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/memstore"
+)
+
+func newTestHandler() (http.Handler, string) {
+	s := memstore.New()
+	now := time.Now()
+	key := s.Put(data.Entry{
+		Course:        data.Course{Title: "converted", AudioLink: "http://a.mp3", Chaire: "Chine", Date: now.AddDate(0, -1, 0), Scraped: now},
+		Converted:     true,
+		Transcript:    "full text",
+		LowConfidence: []data.Span{{StartSec: 3, EndSec: 5, Text: "full", Confidence: 0.4}},
+		NeedsReview:   true,
+	})
+	s.Put(data.Entry{Course: data.Course{Title: "not converted", Chaire: "Chine", Date: now, Scraped: now.Add(-time.Minute)}})
+	return NewHandler(memstore.NewWrapper(s), "/api/lessons"), key
+}
+
+func TestList(t *testing.T) {
+	var tests = []struct {
+		msg        string
+		url        string
+		wantStatus int
+		wantTitles []string
+	}{
+		{
+			msg:        "all",
+			url:        "/api/lessons",
+			wantStatus: http.StatusOK,
+			wantTitles: []string{"converted", "not converted"},
+		}, {
+			msg:        "only converted",
+			url:        "/api/lessons?converted=1",
+			wantStatus: http.StatusOK,
+			wantTitles: []string{"converted"},
+		}, {
+			msg:        "needing review",
+			url:        "/api/lessons?needs_review=1",
+			wantStatus: http.StatusOK,
+			wantTitles: []string{"converted"},
+		}, {
+			msg:        "unconverted of a chaire",
+			url:        "/api/lessons?converted=0&chaire=Chine",
+			wantStatus: http.StatusOK,
+			wantTitles: []string{"not converted"},
+		}, {
+			msg:        "by date",
+			url:        "/api/lessons?order=date",
+			wantStatus: http.StatusOK,
+			wantTitles: []string{"converted", "not converted"},
+		}, {
+			msg:        "other chaire",
+			url:        "/api/lessons?chaire=Inde",
+			wantStatus: http.StatusOK,
+			wantTitles: []string{},
+		}, {
+			msg:        "date range without date order",
+			url:        "/api/lessons?from=2017-01-01",
+			wantStatus: http.StatusBadRequest,
+		}, {
+			msg:        "bad converted",
+			url:        "/api/lessons?converted=yes",
+			wantStatus: http.StatusBadRequest,
+		}, {
+			msg:        "second page",
+			url:        "/api/lessons?size=1&cursor=1",
+			wantStatus: http.StatusOK,
+			wantTitles: []string{"not converted"},
+		}, {
+			msg:        "bad cursor",
+			url:        "/api/lessons?cursor=garbage",
+			wantStatus: http.StatusBadRequest,
+		}, {
+			msg:        "bad size",
+			url:        "/api/lessons?size=0",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		h, _ := newTestHandler()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		resp := w.Result()
+		if got, want := resp.StatusCode, test.wantStatus; got != want {
+			t.Errorf("[%s] resp status code got=%d, want=%d", test.msg, got, want)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		var lr listResponse
+		if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil {
+			t.Fatalf("[%s] decoding response: %v", test.msg, err)
+		}
+		if got, want := len(lr.Lessons), len(test.wantTitles); got != want {
+			t.Errorf("[%s] num lessons got=%d, want=%d", test.msg, got, want)
+			continue
+		}
+		for i, l := range lr.Lessons {
+			if l.Title != test.wantTitles[i] {
+				t.Errorf("[%s] title %d got=%q, want=%q", test.msg, i, l.Title, test.wantTitles[i])
+			}
+			if l.Key == "" || l.Transcript != "" {
+				t.Errorf("[%s] lesson %d got key=%q and transcript=%q, want a key and no transcript", test.msg, i, l.Key, l.Transcript)
+			}
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	h, key := newTestHandler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/lessons/"+key, nil))
+	resp := w.Result()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("resp status code got=%d, want=%d", got, want)
+	}
+	var l lesson
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if l.Title != "converted" || l.AudioLink != "http://a.mp3" || l.Transcript != "full text" || !l.Converted {
+		t.Errorf("lesson got=%+v", l)
+	}
+	if got, want := fmt.Sprint(l.NeedsReview, l.LowConfidence), "true [{3 5 full 0.4}]"; got != want {
+		t.Errorf("passages to review got=%s, want=%s", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/lessons/nope", nil))
+	if got, want := w.Result().StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("missing lesson status code got=%d, want=%d", got, want)
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/attwad/cdf/errorreport"
 	"github.com/attwad/cdf/health"
 	"github.com/attwad/cdf/indexer"
+	"github.com/attwad/cdf/memstore"
 	"github.com/attwad/cdf/money"
 	"github.com/attwad/cdf/pick"
 	"github.com/attwad/cdf/transcribe"
@@ -18,47 +21,66 @@ import (
 )
 
 var (
-	projectID      = flag.String("project_id", "", "Project ID")
-	bucket         = flag.String("bucket", "", "Cloud storage bucket")
-	soxPath        = flag.String("sox_path", "sox", "SOX binary path")
-	elasticAddress = flag.String("elastic_address", "http://elastic:9200", "HTTP address to elastic instance")
+	projectID            = flag.String("project_id", "", "Project ID")
+	backend              = flag.String("backend", "gcp", "Where to store state: \"gcp\" for Datastore and Elasticsearch or \"memory\" to keep everything in memory")
+	memorySeed           = flag.String("memory_seed", "", "JSON file of courses to load, used with --backend=memory")
+	memoryBalance        = flag.Int("memory_balance", 0, "Initial balance in usd cents, used with --backend=memory")
+	bucket               = flag.String("bucket", "", "Cloud storage bucket")
+	storage              = flag.String("storage", "gcs", "Where to store files: \"gcs\" to use --bucket or \"local:/some/dir\" to use a local directory")
+	soxPath              = flag.String("sox_path", "sox", "SOX binary path")
+	ffmpegPath           = flag.String("ffmpeg_path", "ffmpeg", "ffmpeg binary path, used for formats sox cannot read")
+	convertTimeout       = flag.Duration("convert_timeout", transcribe.DefaultConvertTimeout, "Maximum time to convert a downloaded file to FLAC, ffmpeg decodes whole videos, 0 for no limit")
+	parallelism          = flag.Int("parallelism", 1, "How many scheduled courses to process concurrently")
+	transcriber          = flag.String("transcriber", "gspeech", "Speech recognition backend: \"gspeech\" or \"whisper\"")
+	whisperPath          = flag.String("whisper_path", "whisper-cli", "whisper.cpp binary path, used with --transcriber=whisper")
+	whisperModel         = flag.String("whisper_model", "", "whisper.cpp model path, used with --transcriber=whisper")
+	indexBackend         = flag.String("index_backend", "", "Search index: \"elastic\" for elastic search 5.x, \"opensearch\" for OpenSearch or elastic search 8.x, \"meilisearch\", \"sqlite\" (needs the sqlite_fts5 build tag) or \"memory\", defaults to elastic with --backend=gcp and memory with --backend=memory")
+	elasticAddress       = flag.String("elastic_address", "http://elastic:9200", "HTTP address to elastic instance, used with --index_backend=elastic or opensearch")
+	meiliAddress         = flag.String("meilisearch_address", "http://meilisearch:7700", "HTTP address to the Meilisearch instance, used with --index_backend=meilisearch")
+	meiliAPIKey          = flag.String("meilisearch_api_key", "", "Meilisearch API key, used with --index_backend=meilisearch")
+	sqlitePath           = flag.String("sqlite_path", "cdf.db", "SQLite database file, used with --index_backend=sqlite")
+	pollInterval         = flag.Duration("speech_poll_interval", transcribe.DefaultPolling.Interval, "Initial interval between polls of long running speech recognitions, doubled after each poll")
+	maxPollInterval      = flag.Duration("speech_max_poll_interval", transcribe.DefaultPolling.MaxInterval, "Maximum interval between polls of long running speech recognitions")
+	pollRetries          = flag.Int("speech_poll_retries", transcribe.DefaultPolling.Retries, "How many transient errors in a row polling a speech recognition are retried")
+	minSpeechDeadline    = flag.Duration("speech_min_deadline", transcribe.DefaultPolling.MinDeadline, "Minimum time to wait for a long running speech recognition")
+	speechDeadlineFactor = flag.Float64("speech_deadline_factor", transcribe.DefaultPolling.DeadlineFactor, "Long running speech recognitions are waited for the minimum deadline plus this many times the duration of the audio")
+	diarizedTypes        = flag.String("diarized_lesson_types", "Colloque", "Comma separated lesson types whose transcriptions tell speakers apart, empty to never do it")
 )
 
 func main() {
 	flag.Parse()
 	ctx := context.Background()
 
-	er, err := errorreport.NewStackdriverReporter(ctx, *projectID, "worker")
-	if err != nil {
-		log.Fatalf("Creating error reporting client: %v", err)
-	}
-	defer er.Close()
-
-	p, err := pick.NewDatastorePicker(ctx, *projectID)
+	bk, err := newBackends(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
-	u, err := upload.NewGCSFileUploader(ctx, *bucket)
-	if err != nil {
-		log.Fatal(err)
+	er := bk.reporter
+	defer er.Close()
+	// A mismatch is worth a look but does not keep the worker from running.
+	if err := money.CheckLedger(ctx, bk.broker); err != nil {
+		log.Println("[ERROR]: checking ledger:", err)
+		er.Report(fmt.Errorf("Checking ledger: %v", err))
 	}
-	t, err := transcribe.NewGSpeechTranscriber(ctx)
+
+	u, err := upload.NewFileUploader(ctx, *storage, *bucket)
 	if err != nil {
 		log.Fatal(err)
 	}
-	b, err := money.NewDatastoreBroker(ctx, *projectID)
+	t, err := newTranscriber(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Println("Will connect to elastic instance @", *elasticAddress)
 	a := worker.NewGCPWorker(
 		u,
 		t,
-		b,
-		p,
-		indexer.NewElasticIndexer(*elasticAddress),
-		*soxPath,
-		health.NewElasticHealthChecker(*elasticAddress))
+		bk.broker,
+		bk.picker,
+		bk.indexer,
+		transcribe.Tools{SoxPath: *soxPath, FFmpegPath: *ffmpegPath, ConvertTimeout: *convertTimeout},
+		bk.health,
+		*parallelism,
+		lessonTypes(*diarizedTypes))
 	log.Println("Analyzer created, entering loop...")
 	for {
 		if err := a.Run(ctx); err != nil {
@@ -77,3 +99,133 @@ func main() {
 		}
 	}
 }
+
+// backends are the implementations selected by the --backend flag.
+type backends struct {
+	reporter errorreport.Reporter
+	picker   pick.Picker
+	broker   money.Broker
+	indexer  indexer.Indexer
+	health   health.Checker
+}
+
+func newBackends(ctx context.Context) (*backends, error) {
+	switch *backend {
+	case "gcp":
+		er, err := errorreport.NewStackdriverReporter(ctx, *projectID, "worker")
+		if err != nil {
+			return nil, fmt.Errorf("creating error reporting client: %v", err)
+		}
+		p, err := pick.NewDatastorePicker(ctx, *projectID)
+		if err != nil {
+			return nil, err
+		}
+		b, err := money.NewDatastoreBroker(ctx, *projectID)
+		if err != nil {
+			return nil, err
+		}
+		i, h, err := newIndexer("elastic")
+		if err != nil {
+			return nil, err
+		}
+		return &backends{
+			reporter: er,
+			picker:   p,
+			broker:   b,
+			indexer:  i,
+			health:   h,
+		}, nil
+	case "memory":
+		s := memstore.New()
+		if *memorySeed != "" {
+			f, err := os.Open(*memorySeed)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			if err := s.Load(f); err != nil {
+				return nil, err
+			}
+		}
+		b := memstore.NewBroker(s)
+		if *memoryBalance > 0 {
+			if err := b.Deposit(ctx, *memoryBalance, "initial balance"); err != nil {
+				return nil, err
+			}
+		}
+		i, h, err := newIndexer("memory")
+		if err != nil {
+			return nil, err
+		}
+		return &backends{
+			reporter: &memstore.Reporter{},
+			picker:   memstore.NewPicker(s),
+			broker:   b,
+			indexer:  i,
+			health:   h,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", *backend)
+}
+
+// newIndexer creates the Indexer selected by the --index_backend flag and its health checker.
+func newIndexer(defaultBackend string) (indexer.Indexer, health.Checker, error) {
+	b := *indexBackend
+	if b == "" {
+		b = defaultBackend
+	}
+	switch b {
+	case "elastic":
+		log.Println("Will connect to elastic instance @", *elasticAddress)
+		if err := indexer.NewElasticManager(*elasticAddress).EnsureIndex(); err != nil {
+			return nil, nil, fmt.Errorf("ensuring index exists: %v", err)
+		}
+		return indexer.NewElasticIndexer(*elasticAddress), health.NewElasticHealthChecker(*elasticAddress), nil
+	case "opensearch":
+		log.Println("Will connect to OpenSearch instance @", *elasticAddress)
+		if err := indexer.NewOpenSearchManager(*elasticAddress).EnsureIndex(); err != nil {
+			return nil, nil, fmt.Errorf("ensuring index exists: %v", err)
+		}
+		return indexer.NewOpenSearchIndexer(*elasticAddress), health.NewElasticHealthChecker(*elasticAddress), nil
+	case "meilisearch":
+		log.Println("Will connect to Meilisearch instance @", *meiliAddress)
+		i, err := indexer.NewMeilisearchIndexer(*meiliAddress, *meiliAPIKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return i, health.NewMeilisearchHealthChecker(*meiliAddress), nil
+	case "sqlite":
+		return newSQLiteIndexer()
+	case "memory":
+		return &memstore.Indexer{}, memstore.NewHealthChecker(), nil
+	}
+	return nil, nil, fmt.Errorf("unknown index backend %q", b)
+}
+
+// lessonTypes splits a comma separated list of lesson types.
+func lessonTypes(s string) []string {
+	types := make([]string, 0)
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// newTranscriber creates the Transcriber selected by the --transcriber flag.
+func newTranscriber(ctx context.Context) (transcribe.Transcriber, error) {
+	switch *transcriber {
+	case "gspeech":
+		return transcribe.NewGSpeechTranscriber(ctx, transcribe.Polling{
+			Interval:       *pollInterval,
+			MaxInterval:    *maxPollInterval,
+			Retries:        *pollRetries,
+			MinDeadline:    *minSpeechDeadline,
+			DeadlineFactor: *speechDeadlineFactor,
+		})
+	case "whisper":
+		return transcribe.NewWhisperTranscriber(*whisperPath, *whisperModel), nil
+	}
+	return nil, fmt.Errorf("unknown transcriber %q", *transcriber)
+}
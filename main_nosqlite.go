@@ -0,0 +1,16 @@
+//go:build !sqlite_fts5
+// +build !sqlite_fts5
+
+package main
+
+import (
+	"errors"
+
+	"github.com/attwad/cdf/health"
+	"github.com/attwad/cdf/indexer"
+)
+
+// newSQLiteIndexer fails, the SQLite driver is only built in with the sqlite_fts5 build tag, as it needs cgo.
+func newSQLiteIndexer() (indexer.Indexer, health.Checker, error) {
+	return nil, nil, errors.New("the sqlite index backend needs a build with the sqlite_fts5 tag")
+}
@@ -0,0 +1,27 @@
+//go:build sqlite_fts5
+// +build sqlite_fts5
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/attwad/cdf/health"
+	"github.com/attwad/cdf/indexer"
+	// Registers the sqlite3 driver, with FTS5 thanks to the sqlite_fts5 build tag.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newSQLiteIndexer creates an Indexer in the SQLite database at --sqlite_path.
+func newSQLiteIndexer() (indexer.Indexer, health.Checker, error) {
+	db, err := sql.Open("sqlite3", *sqlitePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening sqlite database: %v", err)
+	}
+	i, err := indexer.NewSQLiteIndexer(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	return i, health.NewSQLHealthChecker(db), nil
+}
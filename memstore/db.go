@@ -0,0 +1,55 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/db"
+)
+
+type memWrapper struct {
+	s *Store
+}
+
+// NewWrapper creates a new db.Wrapper backed by the given store.
+// Cursors are offsets in the list of matching lessons.
+func NewWrapper(s *Store) db.Wrapper {
+	return &memWrapper{s}
+}
+
+func (w *memWrapper) GetLessons(ctx context.Context, cursorStr string, filter db.Filter, size int) ([]data.Entry, string, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, "", fmt.Errorf("invalid filter: %v", err)
+	}
+	offset := 0
+	if cursorStr != "" {
+		var err error
+		offset, err = strconv.Atoi(cursorStr)
+		if err != nil {
+			return nil, "", &db.BadCursorError{Cursor: cursorStr, Err: err}
+		}
+		if offset < 0 {
+			return nil, "", &db.BadCursorError{Cursor: cursorStr, Err: fmt.Errorf("negative offset")}
+		}
+	}
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	keys := w.s.sortedKeys(filter.Match, filter.Less)
+	lessons := make([]data.Entry, 0)
+	for i := offset; i < len(keys) && len(lessons) < size; i++ {
+		e := *w.s.entries[keys[i]]
+		e.Key = keys[i]
+		lessons = append(lessons, e)
+	}
+	return lessons, strconv.Itoa(offset + len(lessons)), nil
+}
+
+func (w *memWrapper) GetLesson(ctx context.Context, key string) (data.Entry, error) {
+	e, ok := w.s.Get(key)
+	if !ok {
+		return data.Entry{}, db.ErrNoSuchLesson
+	}
+	return e, nil
+}
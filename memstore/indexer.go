@@ -0,0 +1,55 @@
+package memstore
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/indexer"
+)
+
+// Indexer is an indexer.Indexer that keeps the indexed sentences in memory, by course source and serial.
+type Indexer struct {
+	mu        sync.Mutex
+	sentences map[string]map[int]indexer.Sentence
+}
+
+var _ indexer.Indexer = &Indexer{}
+
+// Index adds the sentences to the ones already indexed for the course, replacing those with the same serial.
+func (i *Indexer) Index(key string, c data.Course, sentences []indexer.Sentence) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.sentences == nil {
+		i.sentences = make(map[string]map[int]indexer.Sentence)
+	}
+	if i.sentences[c.Source] == nil {
+		i.sentences[c.Source] = make(map[int]indexer.Sentence)
+	}
+	for _, s := range sentences {
+		i.sentences[c.Source][s.Serial] = s
+	}
+	return nil
+}
+
+// Delete removes the sentences indexed for the course.
+func (i *Indexer) Delete(c data.Course) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.sentences, c.Source)
+	return nil
+}
+
+// Sentences returns the sentences indexed for the course with the given source, by serial.
+func (i *Indexer) Sentences(source string) []indexer.Sentence {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	sentences := make([]indexer.Sentence, 0, len(i.sentences[source]))
+	for _, s := range i.sentences[source] {
+		sentences = append(sentences, s)
+	}
+	sort.Slice(sentences, func(a, b int) bool {
+		return sentences[a].Serial < sentences[b].Serial
+	})
+	return sentences
+}
@@ -0,0 +1,135 @@
+package memstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/db"
+	"github.com/attwad/cdf/money"
+)
+
+func TestScheduleRandom(t *testing.T) {
+	s := New()
+	s.Put(data.Entry{Course: data.Course{Title: "too long", DurationSec: 7200}})
+	s.Put(data.Entry{Course: data.Course{Title: "converted", DurationSec: 10}, Converted: true})
+	s.Put(data.Entry{Course: data.Course{Title: "failed", DurationSec: 10}, Failed: true})
+	s.Put(data.Entry{Course: data.Course{Title: "hash b", DurationSec: 60}, Hash: []byte("b")})
+	s.Put(data.Entry{Course: data.Course{Title: "hash a", DurationSec: 60}, Hash: []byte("a")})
+	s.Put(data.Entry{Course: data.Course{Title: "longer", DurationSec: 120}, Hash: []byte("0")})
+	p := NewPicker(s)
+	ctx := context.Background()
+	var titles []string
+	for {
+		key, length, err := p.ScheduleRandom(ctx, time.Hour)
+		if err != nil {
+			t.Fatalf("ScheduleRandom: %v", err)
+		}
+		if length == 0 {
+			break
+		}
+		e, _ := s.Get(key)
+		titles = append(titles, e.Title)
+	}
+	if got, want := strings.Join(titles, ", "), "hash a, hash b, longer"; got != want {
+		t.Errorf("scheduled order got=%q, want=%q", got, want)
+	}
+	scheduled, err := p.GetScheduled(ctx)
+	if err != nil {
+		t.Fatalf("GetScheduled: %v", err)
+	}
+	if got, want := len(scheduled), 3; got != want {
+		t.Errorf("num scheduled got=%d, want=%d", got, want)
+	}
+}
+
+func TestGetLessons(t *testing.T) {
+	s := New()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		s.Put(data.Entry{
+			Course:    data.Course{Title: string('a' + rune(i)), Scraped: now.Add(time.Duration(i) * time.Minute)},
+			Converted: i%2 == 0,
+		})
+	}
+	w := NewWrapper(s)
+	ctx := context.Background()
+	var titles []string
+	cursor := ""
+	for {
+		lessons, next, err := w.GetLessons(ctx, cursor, db.Filter{}, 2)
+		if err != nil {
+			t.Fatalf("GetLessons: %v", err)
+		}
+		if len(lessons) == 0 {
+			break
+		}
+		for _, l := range lessons {
+			titles = append(titles, l.Title)
+		}
+		cursor = next
+	}
+	if got, want := strings.Join(titles, ""), "edcba"; got != want {
+		t.Errorf("lessons got=%q, want=%q", got, want)
+	}
+	lessons, _, err := w.GetLessons(ctx, "", db.Filter{Converted: db.Bool(true)}, 10)
+	if err != nil {
+		t.Fatalf("GetLessons: %v", err)
+	}
+	if got, want := len(lessons), 3; got != want {
+		t.Errorf("num converted lessons got=%d, want=%d", got, want)
+	}
+	if _, _, err := w.GetLessons(ctx, "garbage", db.Filter{}, 10); err == nil {
+		t.Error("wanted error on bad cursor but got nil")
+	}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(New())
+	ctx := context.Background()
+	if err := b.Deposit(ctx, 200, "top up"); err != nil {
+		t.Fatalf("Deposit: %v", err)
+	}
+	if err := b.Charge(ctx, "k1", time.Hour); err != nil {
+		t.Fatalf("Charge: %v", err)
+	}
+	if err := b.Charge(ctx, "k2", time.Hour); err != money.ErrInsufficientFunds {
+		t.Errorf("Charge over balance, got err=%v, want=%v", err, money.ErrInsufficientFunds)
+	}
+	if err := b.Refund(ctx, "k1", 44, "failed"); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	// Adjustments may overdraw the balance and are recorded once per course.
+	for i := 0; i < 2; i++ {
+		if err := b.Adjust(ctx, "k1", -150, "longer than scraped"); err != nil {
+			t.Fatalf("Adjust: %v", err)
+		}
+	}
+	balance, err := b.GetBalance(ctx)
+	if err != nil {
+		t.Fatalf("GetBalance: %v", err)
+	}
+	if got, want := balance, -50; got != want {
+		t.Errorf("balance got=%d, want=%d", got, want)
+	}
+	if err := money.CheckLedger(ctx, b); err != nil {
+		t.Errorf("CheckLedger: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s := New()
+	seed := `[{"title": "A lesson", "audio_link": "http://host/a.mp3", "duration_sec": 60}]`
+	if err := s.Load(strings.NewReader(seed)); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	e, ok := s.Get("1")
+	if !ok {
+		t.Fatal("loaded entry not found")
+	}
+	if e.Title != "A lesson" || e.AudioLink != "http://host/a.mp3" || e.DurationSec != 60 {
+		t.Errorf("loaded entry got=%+v", e.Course)
+	}
+}
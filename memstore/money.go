@@ -0,0 +1,100 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/attwad/cdf/money"
+)
+
+type memBroker struct {
+	s *Store
+}
+
+// NewBroker creates a new Broker backed by the given store.
+func NewBroker(s *Store) money.Broker {
+	return &memBroker{s}
+}
+
+func (b *memBroker) GetBalance(ctx context.Context) (int, error) {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	return b.s.balance, nil
+}
+
+func (b *memBroker) Deposit(ctx context.Context, cents int, description string) error {
+	if cents <= 0 {
+		return fmt.Errorf("deposit must be positive, got %d", cents)
+	}
+	return b.record(money.LedgerEntry{
+		Kind:           money.KindDeposit,
+		AmountUsdCents: cents,
+		Description:    description,
+	})
+}
+
+func (b *memBroker) Charge(ctx context.Context, courseKey string, duration time.Duration) error {
+	return b.record(money.LedgerEntry{
+		Kind:           money.KindCharge,
+		AmountUsdCents: -money.DurationToUsdCents(duration),
+		CourseKey:      courseKey,
+		DurationSec:    int(duration.Seconds()),
+		Description:    fmt.Sprintf("conversion of %s of audio", duration),
+	})
+}
+
+func (b *memBroker) Refund(ctx context.Context, courseKey string, cents int, reason string) error {
+	if cents <= 0 {
+		return fmt.Errorf("refund must be positive, got %d", cents)
+	}
+	return b.record(money.LedgerEntry{
+		Kind:           money.KindRefund,
+		AmountUsdCents: cents,
+		CourseKey:      courseKey,
+		Description:    reason,
+	})
+}
+
+func (b *memBroker) Adjust(ctx context.Context, courseKey string, cents int, reason string) error {
+	if cents == 0 {
+		return nil
+	}
+	return b.record(money.LedgerEntry{
+		Kind:           money.KindAdjustment,
+		AmountUsdCents: cents,
+		CourseKey:      courseKey,
+		Description:    reason,
+	})
+}
+
+func (b *memBroker) record(e money.LedgerEntry) error {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	if e.Kind == money.KindAdjustment {
+		for _, l := range b.s.ledger {
+			if l.Kind == money.KindAdjustment && l.CourseKey == e.CourseKey {
+				return nil
+			}
+		}
+	}
+	if e.Kind == money.KindCharge && b.s.balance+e.AmountUsdCents < 0 {
+		return money.ErrInsufficientFunds
+	}
+	e.Time = time.Now()
+	b.s.balance += e.AmountUsdCents
+	b.s.ledger = append(b.s.ledger, e)
+	return nil
+}
+
+func (b *memBroker) History(ctx context.Context, from, to time.Time) ([]money.LedgerEntry, error) {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	entries := make([]money.LedgerEntry, 0)
+	for _, e := range b.s.ledger {
+		if !e.Time.Before(from) && e.Time.Before(to) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,100 @@
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"time"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/pick"
+)
+
+type memPicker struct {
+	s *Store
+}
+
+// NewPicker creates a new Picker backed by the given store.
+func NewPicker(s *Store) pick.Picker {
+	return &memPicker{s}
+}
+
+func (p *memPicker) GetScheduled(ctx context.Context) (map[string]data.Entry, error) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+	courses := make(map[string]data.Entry, 0)
+	for k, e := range p.s.entries {
+		if e.Scheduled {
+			c := *e
+			c.Key = k
+			courses[k] = c
+		}
+	}
+	return courses, nil
+}
+
+func (p *memPicker) ScheduleRandom(ctx context.Context, maxDuration time.Duration) (string, time.Duration, error) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+	// Same as the datastore query: shortest first, then hash-ordered.
+	keys := p.s.sortedKeys(func(e *data.Entry) bool {
+		return !e.Converted && !e.Scheduled && !e.Failed && float64(e.DurationSec) < maxDuration.Seconds()
+	}, func(a, b *data.Entry) bool {
+		if a.DurationSec != b.DurationSec {
+			return a.DurationSec < b.DurationSec
+		}
+		return bytes.Compare(a.Hash, b.Hash) < 0
+	})
+	if len(keys) == 0 {
+		log.Println("Nothing to schedule that is <", maxDuration.Seconds())
+		return "", 0, nil
+	}
+	e := p.s.entries[keys[0]]
+	e.Scheduled = true
+	e.ScheduledTime = time.Now()
+	return keys[0], time.Duration(e.DurationSec) * time.Second, nil
+}
+
+func (p *memPicker) Unschedule(ctx context.Context, key string) error {
+	_, err := p.s.update(key, func(e *data.Entry) {
+		e.Scheduled = false
+	})
+	return err
+}
+
+func (p *memPicker) CorrectDuration(ctx context.Context, key string, durationSec int) error {
+	_, err := p.s.update(key, func(e *data.Entry) {
+		e.DurationSec = durationSec
+	})
+	return err
+}
+
+func (p *memPicker) UpdateProgress(ctx context.Context, key string, progress data.Progress) error {
+	_, err := p.s.update(key, func(e *data.Entry) {
+		e.Progress = progress
+	})
+	return err
+}
+
+func (p *memPicker) MarkConverted(ctx context.Context, key, fullText string, lowConfidence []data.Span) error {
+	_, err := p.s.update(key, func(e *data.Entry) {
+		e.Converted = true
+		e.Scheduled = false
+		e.Transcript = fullText
+		e.LowConfidence = lowConfidence
+		e.NeedsReview = len(lowConfidence) > 0
+		e.Progress = data.Progress{Stage: data.StageDone}
+	})
+	return err
+}
+
+func (p *memPicker) RecordFailure(ctx context.Context, key, reason string, maxRetries int) (data.Entry, error) {
+	return p.s.update(key, func(e *data.Entry) {
+		e.Retries++
+		e.FailureReason = reason
+		if e.Retries >= maxRetries {
+			e.Failed = true
+			e.Scheduled = false
+		}
+	})
+}
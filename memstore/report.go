@@ -0,0 +1,51 @@
+package memstore
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/attwad/cdf/errorreport"
+	"github.com/attwad/cdf/health"
+)
+
+// Reporter is an errorreport.Reporter that logs errors and keeps them in memory.
+type Reporter struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+var _ errorreport.Reporter = &Reporter{}
+
+// Report logs the error and keeps it.
+func (r *Reporter) Report(err error) {
+	log.Println("[REPORTED]:", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, err)
+}
+
+// Errors returns the errors reported so far.
+func (r *Reporter) Errors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.errors...)
+}
+
+// Close does nothing.
+func (r *Reporter) Close() error {
+	return nil
+}
+
+type memHealthChecker struct{}
+
+// NewHealthChecker creates a health.Checker that is always healthy.
+func NewHealthChecker() health.Checker {
+	return memHealthChecker{}
+}
+
+func (memHealthChecker) IsHealthy() bool {
+	return true
+}
+
+func (memHealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
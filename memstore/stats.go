@@ -0,0 +1,40 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+
+	statsio "github.com/attwad/cdf/stats/io"
+)
+
+type memStats struct {
+	s *Store
+}
+
+// NewStatsReader creates a new stats Reader backed by the given store.
+func NewStatsReader(s *Store) statsio.Reader {
+	return &memStats{s}
+}
+
+// NewStatsWriter creates a new stats Writer backed by the given store.
+func NewStatsWriter(s *Store) statsio.Writer {
+	return &memStats{s}
+}
+
+func (m *memStats) Read(ctx context.Context) (*statsio.Stats, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+	if m.s.stats == nil {
+		return nil, errors.New("no stats were computed yet")
+	}
+	s := *m.s.stats
+	return &s, nil
+}
+
+func (m *memStats) Write(ctx context.Context, s *statsio.Stats) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+	c := *s
+	m.s.stats = &c
+	return nil
+}
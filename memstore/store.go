@@ -0,0 +1,129 @@
+// Package memstore implements the storage interfaces of the project in memory, for tests and local development.
+// All implementations created from the same Store share its data.
+package memstore
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/money"
+	statsio "github.com/attwad/cdf/stats/io"
+)
+
+// Store holds the entries, account and stats in memory.
+type Store struct {
+	mu      sync.Mutex
+	entries map[string]*data.Entry
+	nextID  int
+	balance int
+	ledger  []money.LedgerEntry
+	stats   *statsio.Stats
+}
+
+// New creates a new empty store.
+func New() *Store {
+	return &Store{
+		entries: make(map[string]*data.Entry),
+	}
+}
+
+// Put adds the given entry to the store and returns its key.
+// The hash used to pick entries is computed from the source if it is missing.
+func (s *Store) Put(e data.Entry) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	key := strconv.Itoa(s.nextID)
+	if e.Hash == nil {
+		h := sha1.Sum([]byte(e.Source))
+		e.Hash = h[:]
+	}
+	s.entries[key] = &e
+	return key
+}
+
+// Get returns the entry with the given key.
+func (s *Store) Get(key string) (data.Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return data.Entry{}, false
+	}
+	c := *e
+	c.Key = key
+	return c, true
+}
+
+// seedCourse is a course as found in a seed file, with the fields that are not part of the course JSON.
+type seedCourse struct {
+	data.Course
+	Date        time.Time `json:"date"`
+	VideoLink   string    `json:"video_link"`
+	AudioLink   string    `json:"audio_link"`
+	DurationSec int       `json:"duration_sec"`
+}
+
+// Load adds the courses of the JSON array read from r as new entries.
+func (s *Store) Load(r io.Reader) error {
+	var seeds []seedCourse
+	if err := json.NewDecoder(r).Decode(&seeds); err != nil {
+		return fmt.Errorf("decoding seed courses: %v", err)
+	}
+	for _, seed := range seeds {
+		c := seed.Course
+		c.Date = seed.Date
+		c.VideoLink = seed.VideoLink
+		c.AudioLink = seed.AudioLink
+		c.DurationSec = seed.DurationSec
+		if c.Scraped.IsZero() {
+			c.Scraped = time.Now()
+		}
+		s.Put(data.Entry{Course: c})
+	}
+	return nil
+}
+
+// update applies f to the entry with the given key while holding the lock.
+func (s *Store) update(key string, f func(e *data.Entry)) (data.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return data.Entry{}, fmt.Errorf("no entry with key %q", key)
+	}
+	f(e)
+	c := *e
+	c.Key = key
+	return c, nil
+}
+
+// sortedKeys returns the keys of the entries matching the given filter, sorted with the given less function.
+// Must be called while holding the lock.
+func (s *Store) sortedKeys(match func(e *data.Entry) bool, less func(a, b *data.Entry) bool) []string {
+	keys := make([]string, 0)
+	for k, e := range s.entries {
+		if match(e) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := s.entries[keys[i]], s.entries[keys[j]]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		// Keep the order stable for equal entries.
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,89 @@
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/attwad/cdf/upload"
+)
+
+// Uploader is an upload.FileUploader that keeps the files in memory, by name.
+type Uploader struct {
+	// Err is returned by every upload when set.
+	Err error
+
+	mu       sync.Mutex
+	files    map[string][]byte
+	uploaded []string
+	deleted  []string
+}
+
+var _ upload.FileUploader = &Uploader{}
+
+// UploadFile keeps the content of the file, replacing any previous one with the same name.
+func (u *Uploader) UploadFile(ctx context.Context, r io.Reader, name string) error {
+	if u.Err != nil {
+		return u.Err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.files == nil {
+		u.files = make(map[string][]byte)
+	}
+	u.files[name] = b
+	u.uploaded = append(u.uploaded, name)
+	return nil
+}
+
+// Path returns the name of the file as is.
+func (u *Uploader) Path(base string) string {
+	return base
+}
+
+// Delete removes the file.
+func (u *Uploader) Delete(ctx context.Context, name string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.files, name)
+	u.deleted = append(u.deleted, name)
+	return nil
+}
+
+// Download returns the content of the file, upload.ErrNotExist if there is none.
+func (u *Uploader) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	b, ok := u.files[name]
+	if !ok {
+		return nil, upload.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// File returns the content of the file with the given name, empty if there is none.
+func (u *Uploader) File(name string) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return string(u.files[name])
+}
+
+// Uploaded returns the names of the files uploaded so far, in order.
+func (u *Uploader) Uploaded() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.uploaded...)
+}
+
+// Deleted returns the names of the files deleted so far, in order.
+func (u *Uploader) Deleted() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.deleted...)
+}
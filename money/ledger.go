@@ -0,0 +1,66 @@
+package money
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInsufficientFunds is returned when charging more than the current balance.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// Kind is the kind of operation of a ledger entry.
+type Kind string
+
+const (
+	// KindOpening is the balance that existed before the ledger was created.
+	KindOpening Kind = "opening"
+	// KindDeposit is money added to the account.
+	KindDeposit Kind = "deposit"
+	// KindCharge is money spent on converting a course.
+	KindCharge Kind = "charge"
+	// KindRefund is money given back when a course could not be converted.
+	KindRefund Kind = "refund"
+	// KindAdjustment corrects what was charged for a course whose audio does not last as long as scraped.
+	KindAdjustment Kind = "adjustment"
+)
+
+// LedgerEntry is a single line of the append-only account ledger.
+type LedgerEntry struct {
+	Time time.Time
+	Kind Kind
+	// AmountUsdCents is positive when money is added to the account, negative when it is spent.
+	AmountUsdCents int
+	// CourseKey is the course that was charged or refunded, if any.
+	CourseKey string
+	// DurationSec is the duration of audio that was charged, if any.
+	DurationSec int `datastore:",noindex"`
+	// Description explains the operation.
+	Description string `datastore:",noindex"`
+}
+
+// Sum returns the balance that results from the given ledger entries.
+func Sum(entries []LedgerEntry) int {
+	sum := 0
+	for _, e := range entries {
+		sum += e.AmountUsdCents
+	}
+	return sum
+}
+
+// CheckLedger verifies that the balance of the broker matches the sum of its whole ledger.
+func CheckLedger(ctx context.Context, b Broker) error {
+	balance, err := b.GetBalance(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := b.History(ctx, time.Time{}, time.Now())
+	if err != nil {
+		return err
+	}
+	if sum := Sum(entries); sum != balance {
+		return fmt.Errorf("balance is %d usd cents but ledger sums to %d", balance, sum)
+	}
+	return nil
+}
@@ -30,9 +30,23 @@ func DurationToUsdCents(duration time.Duration) int {
 }
 
 // Broker handles the account balance.
+// Every change of the balance is recorded in a ledger.
 type Broker interface {
-	ChangeBalance(ctx context.Context, deltaCents int) error
 	GetBalance(ctx context.Context) (int, error)
+	// Deposit adds money to the account.
+	Deposit(ctx context.Context, cents int, description string) error
+	// Charge spends the cost of converting the given duration of audio of the given course.
+	// Returns ErrInsufficientFunds if the balance is too low.
+	Charge(ctx context.Context, courseKey string, duration time.Duration) error
+	// Refund gives back money that was charged for the given course.
+	Refund(ctx context.Context, courseKey string, cents int, reason string) error
+	// Adjust corrects what was charged for the given course by cents, positive to give money back.
+	// A course is adjusted once, adjusting it again does nothing. As the course was already paid for,
+	// the balance may become negative.
+	Adjust(ctx context.Context, courseKey string, cents int, reason string) error
+	// History returns the ledger entries in [from, to[, oldest first.
+	// In Datastore it needs the LedgerEntry composite index of index.yaml.
+	History(ctx context.Context, from, to time.Time) ([]LedgerEntry, error)
 }
 
 type datastoreBroker struct {
@@ -41,6 +55,7 @@ type datastoreBroker struct {
 }
 
 // NewDatastoreBroker creates a new broker connected to datastore.
+// Ledger entries are stored as children of the account so that they are updated in the same transaction as the balance.
 func NewDatastoreBroker(ctx context.Context, projectID string) (Broker, error) {
 	client, err := datastore.NewClient(ctx, projectID)
 	if err != nil {
@@ -55,22 +70,94 @@ func NewDatastoreBroker(ctx context.Context, projectID string) (Broker, error) {
 	return b, nil
 }
 
-func (b *datastoreBroker) ChangeBalance(ctx context.Context, deltaCents int) error {
-	tx, err := b.client.NewTransaction(ctx)
-	if err != nil {
-		return fmt.Errorf("NewTransaction: %v", err)
+func (b *datastoreBroker) Deposit(ctx context.Context, cents int, description string) error {
+	if cents <= 0 {
+		return fmt.Errorf("deposit must be positive, got %d", cents)
 	}
-	var act account
-	if err := tx.Get(b.key, &act); err != nil {
-		return fmt.Errorf("tx.Get: %v", err)
+	return b.record(ctx, datastore.IncompleteKey("LedgerEntry", b.key), LedgerEntry{
+		Kind:           KindDeposit,
+		AmountUsdCents: cents,
+		Description:    description,
+	})
+}
+
+func (b *datastoreBroker) Charge(ctx context.Context, courseKey string, duration time.Duration) error {
+	cents := DurationToUsdCents(duration)
+	return b.record(ctx, datastore.IncompleteKey("LedgerEntry", b.key), LedgerEntry{
+		Kind:           KindCharge,
+		AmountUsdCents: -cents,
+		CourseKey:      courseKey,
+		DurationSec:    int(duration.Seconds()),
+		Description:    fmt.Sprintf("conversion of %s of audio", duration),
+	})
+}
+
+func (b *datastoreBroker) Refund(ctx context.Context, courseKey string, cents int, reason string) error {
+	if cents <= 0 {
+		return fmt.Errorf("refund must be positive, got %d", cents)
+	}
+	return b.record(ctx, datastore.IncompleteKey("LedgerEntry", b.key), LedgerEntry{
+		Kind:           KindRefund,
+		AmountUsdCents: cents,
+		CourseKey:      courseKey,
+		Description:    reason,
+	})
+}
+
+func (b *datastoreBroker) Adjust(ctx context.Context, courseKey string, cents int, reason string) error {
+	if cents == 0 {
+		return nil
 	}
-	act.BalanceInUsdCents += deltaCents
-	if _, err := tx.Put(b.key, &act); err != nil {
-		return fmt.Errorf("tx.Put: %v", err)
+	// The entry has a key of its own per course so that it is recorded once.
+	return b.record(ctx, datastore.NameKey("LedgerEntry", "adjustment-"+courseKey, b.key), LedgerEntry{
+		Kind:           KindAdjustment,
+		AmountUsdCents: cents,
+		CourseKey:      courseKey,
+		Description:    reason,
+	})
+}
+
+// record appends the entry to the ledger with the given key and updates the balance in a single transaction.
+// Transactions are retried on contention so concurrent workers cannot spend the same money twice.
+// An entry with a named key that is already in the ledger is not recorded again.
+func (b *datastoreBroker) record(ctx context.Context, key *datastore.Key, e LedgerEntry) error {
+	e.Time = time.Now()
+	recorded := false
+	_, err := b.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		recorded = false
+		if key.Name != "" {
+			var existing LedgerEntry
+			if err := tx.Get(key, &existing); err == nil {
+				recorded = true
+				return nil
+			} else if err != datastore.ErrNoSuchEntity {
+				return fmt.Errorf("tx.Get ledger entry: %v", err)
+			}
+		}
+		var act account
+		if err := tx.Get(b.key, &act); err != nil {
+			return fmt.Errorf("tx.Get: %v", err)
+		}
+		if e.Kind == KindCharge && act.BalanceInUsdCents+e.AmountUsdCents < 0 {
+			return ErrInsufficientFunds
+		}
+		act.BalanceInUsdCents += e.AmountUsdCents
+		if _, err := tx.Put(b.key, &act); err != nil {
+			return fmt.Errorf("tx.Put account: %v", err)
+		}
+		if _, err := tx.Put(key, &e); err != nil {
+			return fmt.Errorf("tx.Put ledger entry: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	if _, err := tx.Commit(); err != nil {
-		return fmt.Errorf("tx.Commit: %v", err)
+	if recorded {
+		log.Printf("Ledger: %s of %s was already recorded", e.Kind, e.CourseKey)
+		return nil
 	}
+	log.Printf("Ledger: %s of %d usd cents (%s)", e.Kind, e.AmountUsdCents, e.Description)
 	return nil
 }
 
@@ -82,6 +169,19 @@ func (b *datastoreBroker) GetBalance(ctx context.Context) (int, error) {
 	return act.BalanceInUsdCents, nil
 }
 
+func (b *datastoreBroker) History(ctx context.Context, from, to time.Time) ([]LedgerEntry, error) {
+	query := datastore.NewQuery("LedgerEntry").
+		Ancestor(b.key).
+		Filter("Time >=", from).
+		Filter("Time <", to).
+		Order("Time")
+	entries := make([]LedgerEntry, 0)
+	if _, err := b.client.GetAll(ctx, query, &entries); err != nil {
+		return nil, fmt.Errorf("client.GetAll: %v", err)
+	}
+	return entries, nil
+}
+
 func (b *datastoreBroker) init(ctx context.Context) error {
 	var act account
 	if err := b.client.Get(ctx, accountKey, &act); err != nil {
@@ -99,5 +199,37 @@ func (b *datastoreBroker) init(ctx context.Context) error {
 		}
 	}
 	b.key = accountKey
-	return nil
+	return b.openLedger(ctx)
+}
+
+// openLedger records the existing balance as the first ledger entry if the ledger is empty,
+// so that the balance of accounts created before the ledger existed matches their ledger.
+// The ledger is checked in the transaction so that workers starting together open it once.
+func (b *datastoreBroker) openLedger(ctx context.Context) error {
+	_, err := b.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		query := datastore.NewQuery("LedgerEntry").Ancestor(b.key).KeysOnly().Limit(1).Transaction(tx)
+		keys, err := b.client.GetAll(ctx, query, nil)
+		if err != nil {
+			return fmt.Errorf("checking ledger: %v", err)
+		}
+		if len(keys) > 0 {
+			return nil
+		}
+		var act account
+		if err := tx.Get(b.key, &act); err != nil {
+			return fmt.Errorf("tx.Get: %v", err)
+		}
+		if act.BalanceInUsdCents == 0 {
+			return nil
+		}
+		log.Println("Opening ledger with existing balance", act.BalanceInUsdCents)
+		_, err = tx.Put(datastore.IncompleteKey("LedgerEntry", b.key), &LedgerEntry{
+			Time:           time.Now(),
+			Kind:           KindOpening,
+			AmountUsdCents: act.BalanceInUsdCents,
+			Description:    "balance before the ledger existed",
+		})
+		return err
+	})
+	return err
 }
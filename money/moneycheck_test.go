@@ -1,6 +1,7 @@
 package money
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -16,3 +17,32 @@ func TestDurationToEurCents(t *testing.T) {
 		t.Errorf("got=%d, want=%d", got, want)
 	}
 }
+
+type fakeBroker struct {
+	Broker
+	balance int
+	history []LedgerEntry
+}
+
+func (b *fakeBroker) GetBalance(ctx context.Context) (int, error) {
+	return b.balance, nil
+}
+
+func (b *fakeBroker) History(ctx context.Context, from, to time.Time) ([]LedgerEntry, error) {
+	return b.history, nil
+}
+
+func TestCheckLedger(t *testing.T) {
+	history := []LedgerEntry{
+		{Kind: KindDeposit, AmountUsdCents: 500},
+		{Kind: KindCharge, AmountUsdCents: -144},
+		{Kind: KindRefund, AmountUsdCents: 44},
+	}
+	ctx := context.Background()
+	if err := CheckLedger(ctx, &fakeBroker{balance: 400, history: history}); err != nil {
+		t.Errorf("CheckLedger: %v", err)
+	}
+	if err := CheckLedger(ctx, &fakeBroker{balance: 500, history: history}); err == nil {
+		t.Error("CheckLedger: wanted error on mismatched balance but got nil")
+	}
+}
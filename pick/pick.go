@@ -15,9 +15,22 @@ import (
 
 // Picker allows access to items and scheduling.
 type Picker interface {
-	GetScheduled(ctx context.Context) (map[string]data.Course, error)
-	ScheduleRandom(ctx context.Context, maxDuration time.Duration) (time.Duration, error)
-	MarkConverted(ctx context.Context, key, fullText string) error
+	GetScheduled(ctx context.Context) (map[string]data.Entry, error)
+	// ScheduleRandom schedules an entry shorter than maxDuration and returns its key and duration.
+	// The duration is zero if nothing could be scheduled.
+	ScheduleRandom(ctx context.Context, maxDuration time.Duration) (string, time.Duration, error)
+	// Unschedule cancels the scheduling of the given entry.
+	Unschedule(ctx context.Context, key string) error
+	// CorrectDuration replaces the scraped duration of the given entry with the real duration of its audio.
+	CorrectDuration(ctx context.Context, key string, durationSec int) error
+	// UpdateProgress saves how far along the conversion pipeline the given entry is.
+	UpdateProgress(ctx context.Context, key string, p data.Progress) error
+	// MarkConverted saves the transcript of the given entry and its passages to review, and marks it as converted.
+	MarkConverted(ctx context.Context, key, fullText string, lowConfidence []data.Span) error
+	// RecordFailure records that the conversion of the given entry failed for the given reason.
+	// Once it failed maxRetries times the entry is marked as failed and unscheduled.
+	// Returns the updated entry.
+	RecordFailure(ctx context.Context, key, reason string, maxRetries int) (data.Entry, error)
 }
 
 type datastorePicker struct {
@@ -35,70 +48,127 @@ func NewDatastorePicker(ctx context.Context, projectID string) (Picker, error) {
 	}, nil
 }
 
-func (p *datastorePicker) MarkConverted(ctx context.Context, key, fullText string) error {
-	tx, err := p.client.NewTransaction(ctx)
-	if err != nil {
-		return fmt.Errorf("NewTransaction: %v", err)
-	}
-	var e data.Entry
-	k, err := datastore.DecodeKey(key)
-	if err != nil {
-		return fmt.Errorf("decode key: %s", err)
-	}
-	if err := tx.Get(k, &e); err != nil {
-		return fmt.Errorf("tx.Get: %v", err)
-	}
-	e.Converted = true
-	e.Scheduled = false
-	e.Transcript = fullText
-	if _, err := tx.Put(k, &e); err != nil {
-		return fmt.Errorf("tx.Put: %v", err)
-	}
-	if _, err := tx.Commit(); err != nil {
-		return fmt.Errorf("tx.Commit: %v", err)
-	}
-	return nil
+func (p *datastorePicker) MarkConverted(ctx context.Context, key, fullText string, lowConfidence []data.Span) error {
+	_, err := p.updateEntry(ctx, key, func(e *data.Entry) error {
+		e.Converted = true
+		e.Scheduled = false
+		e.Transcript = fullText
+		e.LowConfidence = lowConfidence
+		e.NeedsReview = len(lowConfidence) > 0
+		e.Progress = data.Progress{Stage: data.StageDone}
+		return nil
+	})
+	return err
+}
+
+func (p *datastorePicker) UpdateProgress(ctx context.Context, key string, progress data.Progress) error {
+	_, err := p.updateEntry(ctx, key, func(e *data.Entry) error {
+		e.Progress = progress
+		return nil
+	})
+	return err
+}
+
+func (p *datastorePicker) RecordFailure(ctx context.Context, key, reason string, maxRetries int) (data.Entry, error) {
+	return p.updateEntry(ctx, key, func(e *data.Entry) error {
+		e.Retries++
+		e.FailureReason = reason
+		if e.Retries >= maxRetries {
+			e.Failed = true
+			e.Scheduled = false
+		}
+		return nil
+	})
 }
 
-func (p *datastorePicker) ScheduleRandom(ctx context.Context, maxDuration time.Duration) (time.Duration, error) {
+func (p *datastorePicker) ScheduleRandom(ctx context.Context, maxDuration time.Duration) (string, time.Duration, error) {
 	// Pick a random (hash-ordered) entry that is not scheduled and not converted yet.
+	// Failed entries are skipped here rather than filtered in the query as
+	// entries created before the Failed field existed would not match.
 	query := datastore.NewQuery("Entry").
 		Filter("Converted =", false).
 		Filter("Scheduled =", false).
 		Filter("DurationSec <", maxDuration.Seconds()).
 		Order("DurationSec").
-		Order("Hash").
-		Limit(1)
+		Order("Hash")
 	var e data.Entry
+	var key *datastore.Key
 	it := p.client.Run(ctx, query)
 	for {
-		key, err := it.Next(&e)
+		e = data.Entry{}
+		var err error
+		key, err = it.Next(&e)
 		for err == iterator.Done {
 			log.Println("Nothing to schedule that is <", maxDuration.Seconds())
-			return 0, nil
+			return "", 0, nil
 		}
 		if err != nil {
-			return 0, fmt.Errorf("failed fetching results: %v", err)
+			return "", 0, fmt.Errorf("failed fetching results: %v", err)
+		}
+		if e.Failed {
+			continue
 		}
 		e.Scheduled = true
 		e.ScheduledTime = time.Now()
 		if _, err := p.client.Put(ctx, key, &e); err != nil {
-			return 0, fmt.Errorf("client.Put: %v", err)
+			return "", 0, fmt.Errorf("client.Put: %v", err)
 		}
 		break
 	}
 
-	return time.Duration(e.DurationSec) * time.Second, nil
+	return key.Encode(), time.Duration(e.DurationSec) * time.Second, nil
 }
 
-func (p *datastorePicker) GetScheduled(ctx context.Context) (map[string]data.Course, error) {
-	// Pick a random (has-ordered) entry that is not scheduled and not converted yet.
+func (p *datastorePicker) CorrectDuration(ctx context.Context, key string, durationSec int) error {
+	_, err := p.updateEntry(ctx, key, func(e *data.Entry) error {
+		e.DurationSec = durationSec
+		return nil
+	})
+	return err
+}
+
+func (p *datastorePicker) Unschedule(ctx context.Context, key string) error {
+	_, err := p.updateEntry(ctx, key, func(e *data.Entry) error {
+		e.Scheduled = false
+		return nil
+	})
+	return err
+}
+
+// updateEntry applies f to the entry with the given key in a transaction, retried on contention,
+// and returns the updated entry.
+func (p *datastorePicker) updateEntry(ctx context.Context, key string, f func(*data.Entry) error) (data.Entry, error) {
+	k, err := datastore.DecodeKey(key)
+	if err != nil {
+		return data.Entry{}, fmt.Errorf("decode key: %s", err)
+	}
+	var e data.Entry
+	_, err = p.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		e = data.Entry{}
+		if err := tx.Get(k, &e); err != nil {
+			return fmt.Errorf("tx.Get: %v", err)
+		}
+		if err := f(&e); err != nil {
+			return err
+		}
+		if _, err := tx.Put(k, &e); err != nil {
+			return fmt.Errorf("tx.Put: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return data.Entry{}, err
+	}
+	return e, nil
+}
+
+func (p *datastorePicker) GetScheduled(ctx context.Context) (map[string]data.Entry, error) {
 	query := datastore.NewQuery("Entry").
 		Filter("Scheduled =", true)
-	var e data.Entry
 	it := p.client.Run(ctx, query)
-	courses := make(map[string]data.Course, 0)
+	courses := make(map[string]data.Entry, 0)
 	for {
+		var e data.Entry
 		k, err := it.Next(&e)
 		for err == iterator.Done {
 			return courses, nil
@@ -106,6 +176,7 @@ func (p *datastorePicker) GetScheduled(ctx context.Context) (map[string]data.Cou
 		if err != nil {
 			return nil, fmt.Errorf("failed fetching results: %v", err)
 		}
-		courses[k.Encode()] = e.Course
+		e.Key = k.Encode()
+		courses[e.Key] = e
 	}
 }
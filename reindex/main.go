@@ -0,0 +1,127 @@
+// Package main builds a new version of the search index, then atomically points the alias searches use to it.
+// Documents of an index built by the worker are copied as they are, older indices are rebuilt from the transcripts
+// in datastore. The worker keeps writing to the previous version meanwhile, what it wrote is copied once more before
+// and after the swap.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/attwad/cdf/db"
+	"github.com/attwad/cdf/indexer"
+	"github.com/attwad/cdf/segment"
+	"github.com/attwad/cdf/transcribe"
+)
+
+const pageSize = 100
+
+var (
+	projectID      = flag.String("project_id", "college-de-france", "Cloud project ID")
+	indexBackend   = flag.String("index_backend", "elastic", "\"elastic\" for elastic search 5.x or \"opensearch\" for OpenSearch or elastic search 8.x")
+	elasticAddress = flag.String("elastic_address", "http://elastic:9200", "HTTP address to elastic instance")
+	version        = flag.Int("version", 0, "Version of the index to build, defaults to the one after the current version")
+	swap           = flag.Bool("swap", true, "Whether to point the alias to the new version once it is built")
+	fromDatastore  = flag.Bool("from_datastore", false, "Rebuild from the transcripts in datastore even if the current version can be copied")
+)
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+	var m indexer.Manager
+	switch *indexBackend {
+	case "elastic":
+		m = indexer.NewElasticManager(*elasticAddress)
+	case "opensearch":
+		m = indexer.NewOpenSearchManager(*elasticAddress)
+	default:
+		log.Fatalf("Unknown index backend %q", *indexBackend)
+	}
+	d, err := db.NewDatastoreWrapper(ctx, *projectID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	current, err := m.CurrentVersion()
+	if err != nil {
+		log.Fatalf("Getting current version: %v", err)
+	}
+	v := *version
+	if v == 0 {
+		v = current + 1
+		if v < indexer.FirstVersion {
+			v = indexer.FirstVersion
+		}
+	}
+	log.Printf("Current version is %d, building %s", current, indexer.IndexName(v))
+	if err := m.CreateVersion(v); err != nil {
+		log.Fatalf("Creating version %d: %v", v, err)
+	}
+	// Sources of the courses rebuilt from datastore, the documents of the others are copied.
+	rebuilt := make([]string, 0)
+	if current >= indexer.FirstVersion && !*fromDatastore {
+		log.Printf("Copying %s to %s", indexer.IndexName(current), indexer.IndexName(v))
+	} else {
+		rebuilt, err = rebuild(ctx, d, m.Indexer(v))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Copying also catches up on what the worker indexed in the current version while the new one was built,
+	// the documents already in the new version are kept.
+	if current > 0 {
+		if err := m.Copy(current, v, rebuilt); err != nil {
+			log.Fatalf("Copying %d to %d: %v", current, v, err)
+		}
+	}
+	if !*swap {
+		log.Printf("Not swapping, %s is ready", indexer.IndexName(v))
+		return
+	}
+	if err := m.SwapAlias(v); err != nil {
+		log.Fatalf("Swapping alias: %v", err)
+	}
+	log.Printf("Alias %s now points to %s", indexer.Alias, indexer.IndexName(v))
+	// The worker may have indexed more in the previous version until the swap, courses being converted included.
+	// An index named Alias is deleted by the swap, there is nothing more to copy from it.
+	if current >= indexer.FirstVersion {
+		if err := m.Copy(current, v, rebuilt); err != nil {
+			log.Fatalf("Copying %d to %d after the swap: %v", current, v, err)
+		}
+	}
+	log.Printf("%s is complete, the previous version can be deleted", indexer.IndexName(v))
+}
+
+// rebuild indexes the transcripts of the converted courses in datastore and returns their sources.
+func rebuild(ctx context.Context, d db.Wrapper, i indexer.Indexer) ([]string, error) {
+	filter := db.Filter{Converted: db.Bool(true)}
+	cursor := ""
+	sources := make([]string, 0)
+	for {
+		lessons, nextCursor, err := d.GetLessons(ctx, cursor, filter, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		cursor = nextCursor
+		if len(lessons) == 0 {
+			return sources, nil
+		}
+		for _, lesson := range lessons {
+			// The full transcript does not keep when sentences start, they are estimated as if it was a single
+			// transcription, and indexed by windows as the worker does.
+			t := transcribe.Transcription{Text: lesson.Transcript, End: time.Duration(lesson.DurationSec) * time.Second}
+			split := segment.Split(lesson.Language, []transcribe.Transcription{t})
+			sentences := make([]indexer.Sentence, 0)
+			for j, w := range segment.Windows(split, segment.WindowSize, segment.WindowOverlap) {
+				sentences = append(sentences, indexer.Sentence{Serial: j, Text: w.Text, Start: w.Start, End: w.End})
+			}
+			if err := i.Index(lesson.Key, lesson.Course, sentences); err != nil {
+				return nil, fmt.Errorf("indexing %s: %v", lesson.Source, err)
+			}
+			sources = append(sources, lesson.Source)
+		}
+		log.Println("Indexed", len(sources), "lessons")
+	}
+}
@@ -0,0 +1,78 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultSize = 10
+	maxSize     = 50
+)
+
+type handler struct {
+	searcher Searcher
+}
+
+// NewHandler returns an HTTP handler that serves the results of the query described by the URL parameters as JSON:
+// q (required), lecturer, chaire, lang, speaker (from 1, in transcripts that tell speakers apart), from and size.
+func NewHandler(s Searcher) http.Handler {
+	return &handler{s}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := h.searcher.Search(r.Context(), q)
+	if err != nil {
+		log.Println("Searching:", err)
+		http.Error(w, "search failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Println("Encoding results:", err)
+	}
+}
+
+func parseQuery(r *http.Request) (Query, error) {
+	v := r.URL.Query()
+	q := Query{
+		Text:     v.Get("q"),
+		Lecturer: v.Get("lecturer"),
+		Chaire:   v.Get("chaire"),
+		Language: v.Get("lang"),
+		Size:     defaultSize,
+	}
+	if q.Text == "" {
+		return q, fmt.Errorf("missing q parameter")
+	}
+	if speaker := v.Get("speaker"); speaker != "" {
+		n, err := strconv.Atoi(speaker)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("bad speaker parameter %q", speaker)
+		}
+		q.Speaker = n
+	}
+	if from := v.Get("from"); from != "" {
+		n, err := strconv.Atoi(from)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("bad from parameter %q", from)
+		}
+		q.From = n
+	}
+	if size := v.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 || n > maxSize {
+			return q, fmt.Errorf("bad size parameter %q, must be in [1, %d]", size, maxSize)
+		}
+		q.Size = n
+	}
+	return q, nil
+}
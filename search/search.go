@@ -0,0 +1,247 @@
+// Package search queries the transcripts indexed by the indexer package.
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/indexer"
+)
+
+// Query is a full text search in the transcripts, optionally filtered on course fields.
+type Query struct {
+	// Text to search for in the transcripts.
+	Text     string
+	Lecturer string
+	Chaire   string
+	Language string
+	// Speaker restricts the search to the sentences of a speaker of diarized courses when not zero.
+	Speaker int
+	// From and Size paginate over the matching courses.
+	From int
+	Size int
+}
+
+// Snippet is a highlighted sentence of a transcript that matched the query.
+type Snippet struct {
+	Serial int `json:"serial"`
+	// StartSec and EndSec are when the sentence starts and ends in the audio.
+	StartSec int `json:"start_sec"`
+	EndSec   int `json:"end_sec,omitempty"`
+	// Text of the sentence, matching words are surrounded with <em></em>.
+	Text string `json:"text"`
+	// Confidence of the speech recognition of the sentence, omitted if unknown.
+	Confidence float32 `json:"confidence,omitempty"`
+	// Speaker of the sentence in diarized courses, omitted if unknown.
+	Speaker int `json:"speaker,omitempty"`
+}
+
+// CourseResult is a course that matched the query with its best matching snippets.
+// Fields the course JSON omits are added when the index has them.
+type CourseResult struct {
+	data.Course
+	Key         string     `json:"key,omitempty"`
+	Date        *time.Time `json:"date,omitempty"`
+	AudioLink   string     `json:"audio_link,omitempty"`
+	VideoLink   string     `json:"video_link,omitempty"`
+	DurationSec int        `json:"duration_sec,omitempty"`
+	Snippets    []Snippet  `json:"snippets"`
+}
+
+// Results are the courses matching a query.
+type Results struct {
+	// TotalCourses is the total number of courses matching the query, not only the ones in this page.
+	TotalCourses int            `json:"total_courses"`
+	Courses      []CourseResult `json:"courses"`
+}
+
+// Searcher searches the transcripts.
+type Searcher interface {
+	Search(ctx context.Context, q Query) (*Results, error)
+}
+
+// snippetsPerCourse is how many matching sentences are returned for each course.
+const snippetsPerCourse = 3
+
+type elasticSearcher struct {
+	client *http.Client
+	host   string
+}
+
+// NewElasticSearcher creates a new Searcher that queries elastic search.
+func NewElasticSearcher(host string) Searcher {
+	return &elasticSearcher{
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+		host: host,
+	}
+}
+
+// esHit is a transcript document as returned by elastic search.
+type esHit struct {
+	Source indexer.Document `json:"_source"`
+	// Highlight is keyed by transcript field.
+	Highlight map[string][]string `json:"highlight"`
+	InnerHits struct {
+		Snippets struct {
+			Hits struct {
+				Hits []esHit `json:"hits"`
+			} `json:"hits"`
+		} `json:"snippets"`
+	} `json:"inner_hits"`
+}
+
+type esResponse struct {
+	Hits struct {
+		Hits []esHit `json:"hits"`
+	} `json:"hits"`
+	Aggregations struct {
+		Courses struct {
+			Value int `json:"value"`
+		} `json:"courses"`
+	} `json:"aggregations"`
+}
+
+// transcriptFields are searched for the query text, the transcript is also in a field analyzed for its language if supported.
+var transcriptFields = []string{"transcript_fr", "transcript_en", "transcript"}
+
+// buildQuery builds the elastic search request body.
+// Scores are multiplied by the confidence of the speech recognition so that poorly recognized sentences rank lower,
+// sentences without confidence are not penalized.
+// Sentences are collapsed by course so that pagination applies to courses rather than sentences.
+func buildQuery(q Query) map[string]interface{} {
+	filters := make([]interface{}, 0)
+	addTerm := func(field, value string) {
+		if value != "" {
+			filters = append(filters, map[string]interface{}{
+				"term": map[string]interface{}{field: value},
+			})
+		}
+	}
+	addTerm("lecturer.keyword", q.Lecturer)
+	addTerm("chaire.keyword", q.Chaire)
+	addTerm("lang.keyword", q.Language)
+	if q.Speaker != 0 {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"speaker": q.Speaker},
+		})
+	}
+	highlightFields := make(map[string]interface{})
+	for _, f := range transcriptFields {
+		highlightFields[f] = map[string]interface{}{"number_of_fragments": 0}
+	}
+	highlight := map[string]interface{}{"fields": highlightFields}
+	return map[string]interface{}{
+		"from":    q.From,
+		"size":    q.Size,
+		"_source": []string{"course_key", "title", "lecturer", "function", "date", "lesson_type", "type_title", "chaire", "lang", "source_url", "audio_link", "video_link", "duration_sec"},
+		"query": map[string]interface{}{
+			"function_score": map[string]interface{}{
+				"query": map[string]interface{}{
+					"bool": map[string]interface{}{
+						"must": map[string]interface{}{
+							"multi_match": map[string]interface{}{"query": q.Text, "fields": transcriptFields},
+						},
+						"filter": filters,
+					},
+				},
+				"field_value_factor": map[string]interface{}{"field": "confidence", "missing": 1},
+				"boost_mode":         "multiply",
+			},
+		},
+		"collapse": map[string]interface{}{
+			"field": "source_url.keyword",
+			"inner_hits": map[string]interface{}{
+				"name":      "snippets",
+				"size":      snippetsPerCourse,
+				"_source":   []string{"Serial", "transcript", "start_sec", "end_sec", "confidence", "speaker"},
+				"highlight": highlight,
+			},
+		},
+		"aggs": map[string]interface{}{
+			"courses": map[string]interface{}{
+				"cardinality": map[string]interface{}{"field": "source_url.keyword"},
+			},
+		},
+	}
+}
+
+func (s *elasticSearcher) Search(ctx context.Context, q Query) (*Results, error) {
+	body, err := json.Marshal(buildQuery(q))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", s.host+"/course/_search", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search failed with status %s: %s", resp.Status, respBody)
+	}
+	var er esResponse
+	if err := json.Unmarshal(respBody, &er); err != nil {
+		return nil, fmt.Errorf("unmarshall response body: %v", err)
+	}
+	results := &Results{
+		TotalCourses: er.Aggregations.Courses.Value,
+		Courses:      make([]CourseResult, 0),
+	}
+	for _, hit := range er.Hits.Hits {
+		d := hit.Source
+		cr := CourseResult{
+			Course: data.Course{
+				Title:      d.Title,
+				Lecturer:   d.Lecturer,
+				Function:   d.Function,
+				LessonType: d.LessonType,
+				TypeTitle:  d.TypeTitle,
+				Chaire:     d.Chaire,
+				Language:   d.Language,
+				Source:     d.Source,
+			},
+			Key:         d.CourseKey,
+			Date:        d.Date,
+			AudioLink:   d.AudioLink,
+			VideoLink:   d.VideoLink,
+			DurationSec: d.DurationSec,
+			Snippets:    make([]Snippet, 0),
+		}
+		for _, inner := range hit.InnerHits.Snippets.Hits.Hits {
+			text := inner.Source.Transcript
+			for _, f := range transcriptFields {
+				if h := inner.Highlight[f]; len(h) > 0 {
+					text = strings.Join(h, " … ")
+					break
+				}
+			}
+			cr.Snippets = append(cr.Snippets, Snippet{
+				Serial:     inner.Source.Serial,
+				StartSec:   inner.Source.StartSec,
+				EndSec:     inner.Source.EndSec,
+				Text:       text,
+				Confidence: inner.Source.Confidence,
+				Speaker:    inner.Source.Speaker,
+			})
+		}
+		results.Courses = append(results.Courses, cr)
+	}
+	return results, nil
+}
@@ -0,0 +1,144 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Path, "/course/_search"; got != want {
+			t.Errorf("request path got=%q, want=%q", got, want)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Could not read request sent to server %v", err)
+			return
+		}
+		s := string(b)
+		for _, want := range []string{`"query":"opium"`, `"transcript_fr"`, `"lecturer.keyword":"John Doe"`, `"from":10`, `"collapse"`, `"field_value_factor"`, `"speaker":2`} {
+			if !strings.Contains(s, want) {
+				t.Errorf("Missing %s in request sent to server: %s", want, s)
+			}
+		}
+		io.WriteString(w, `{
+			"hits": {"total": 12, "hits": [{
+				"_source": {"course_key": "k1", "title": "A lesson", "lecturer": "John Doe", "source_url": "http://a", "audio_link": "http://a.mp3"},
+				"inner_hits": {"snippets": {"hits": {"hits": [
+					{"_source": {"Serial": 4, "transcript": "the opium trade", "start_sec": 42, "end_sec": 45, "confidence": 0.5, "speaker": 2}, "highlight": {"transcript_fr": ["the <em>opium</em> trade"]}},
+					{"_source": {"Serial": 7, "transcript": "no highlight", "start_sec": 60}}
+				]}}}
+			}]},
+			"aggregations": {"courses": {"value": 11}}
+		}`)
+	}))
+	defer ts.Close()
+
+	s := NewElasticSearcher(ts.URL)
+	results, err := s.Search(context.Background(), Query{Text: "opium", Lecturer: "John Doe", Speaker: 2, From: 10, Size: 5})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got, want := results.TotalCourses, 11; got != want {
+		t.Errorf("total courses got=%d, want=%d", got, want)
+	}
+	if got, want := len(results.Courses), 1; got != want {
+		t.Fatalf("num courses got=%d, want=%d", got, want)
+	}
+	c := results.Courses[0]
+	if got, want := c.Title, "A lesson"; got != want {
+		t.Errorf("title got=%q, want=%q", got, want)
+	}
+	if got, want := c.Key+" "+c.AudioLink, "k1 http://a.mp3"; got != want {
+		t.Errorf("key and audio link got=%q, want=%q", got, want)
+	}
+	if got, want := fmt.Sprint(c.Snippets), "[{4 42 45 the <em>opium</em> trade 0.5 2} {7 60 0 no highlight 0 0}]"; got != want {
+		t.Errorf("snippets got=%s, want=%s", got, want)
+	}
+}
+
+func TestSearchFails(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"index_not_found_exception"}`, http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	s := NewElasticSearcher(ts.URL)
+	if _, err := s.Search(context.Background(), Query{Text: "opium", Size: 5}); err == nil {
+		t.Error("wanted error but got nil")
+	}
+}
+
+type fakeSearcher struct {
+	query Query
+}
+
+func (f *fakeSearcher) Search(ctx context.Context, q Query) (*Results, error) {
+	f.query = q
+	return &Results{TotalCourses: 1, Courses: []CourseResult{{Snippets: []Snippet{{Text: "hello"}}}}}, nil
+}
+
+func TestHandler(t *testing.T) {
+	var tests = []struct {
+		msg        string
+		url        string
+		wantStatus int
+		wantQuery  Query
+	}{
+		{
+			msg:        "defaults",
+			url:        "/api/search?q=opium",
+			wantStatus: http.StatusOK,
+			wantQuery:  Query{Text: "opium", Size: defaultSize},
+		}, {
+			msg:        "all parameters",
+			url:        "/api/search?q=opium&lecturer=John+Doe&chaire=Chine&lang=fr&speaker=2&from=20&size=5",
+			wantStatus: http.StatusOK,
+			wantQuery:  Query{Text: "opium", Lecturer: "John Doe", Chaire: "Chine", Language: "fr", Speaker: 2, From: 20, Size: 5},
+		}, {
+			msg:        "missing q",
+			url:        "/api/search?lecturer=John",
+			wantStatus: http.StatusBadRequest,
+		}, {
+			msg:        "bad size",
+			url:        "/api/search?q=opium&size=1000",
+			wantStatus: http.StatusBadRequest,
+		}, {
+			msg:        "bad speaker",
+			url:        "/api/search?q=opium&speaker=0",
+			wantStatus: http.StatusBadRequest,
+		}, {
+			msg:        "bad from",
+			url:        "/api/search?q=opium&from=-1",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		fs := &fakeSearcher{}
+		h := NewHandler(fs)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		resp := w.Result()
+		if got, want := resp.StatusCode, test.wantStatus; got != want {
+			t.Errorf("[%s] resp status code got=%d, want=%d", test.msg, got, want)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if got, want := fs.query, test.wantQuery; got != want {
+			t.Errorf("[%s] query got=%+v, want=%+v", test.msg, got, want)
+		}
+		var results Results
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			t.Errorf("[%s] decoding response: %v", test.msg, err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Package segment splits transcriptions into sentences, and sentences into overlapping windows to be indexed.
+package segment
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/transcribe"
+)
+
+// maxWords is the length after which a sentence is cut even without punctuation, transcripts may have none.
+const maxWords = 50
+
+// Sentences are indexed by windows of WindowSize sentences, consecutive windows sharing WindowOverlap sentences.
+const (
+	WindowSize    = 3
+	WindowOverlap = 1
+)
+
+// Sentence is a piece of transcript and when it is said in the audio.
+type Sentence struct {
+	Text  string
+	Start time.Duration
+	End   time.Duration
+	// Confidence is the average confidence of the recognition of the words of the sentence, 0 if unknown.
+	Confidence float32
+	// Speaker of the sentence, 0 if unknown.
+	Speaker int
+}
+
+// word is a transcribed word with the confidence of its transcription.
+type word struct {
+	transcribe.Word
+	confidence float32
+}
+
+// abbreviations end with a period that does not end a sentence, by base language.
+var abbreviations = map[string]map[string]bool{
+	"fr": set("m.", "mm.", "mme.", "mlle.", "dr.", "pr.", "me.", "st.", "ste.", "cf.", "p.", "pp.", "av.", "apr.", "j.-c.", "env.", "éd.", "vol.", "chap.", "n°."),
+	"en": set("mr.", "mrs.", "ms.", "dr.", "prof.", "st.", "vs.", "e.g.", "i.e.", "cf.", "p.", "pp.", "no.", "vol.", "ch.", "b.c.", "a.d."),
+}
+
+func set(words ...string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range words {
+		m[w] = true
+	}
+	return m
+}
+
+// Split splits transcriptions into sentences on their punctuation, ignoring the periods of abbreviations
+// of the given language, and cuts sentences longer than maxWords or where the speaker changes.
+// Word offsets are used when known, otherwise the words are assumed to be evenly spread over their transcription.
+func Split(lang string, ts []transcribe.Transcription) []Sentence {
+	abbrevs := abbreviations[data.BaseLanguage(lang)]
+	sentences := make([]Sentence, 0)
+	var current []word
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		texts := make([]string, 0, len(current))
+		confidences := make([]float32, 0, len(current))
+		for _, w := range current {
+			texts = append(texts, w.Text)
+			confidences = append(confidences, w.confidence)
+		}
+		sentences = append(sentences, Sentence{
+			Text:       strings.Join(texts, " "),
+			Start:      current[0].Start,
+			End:        current[len(current)-1].End,
+			Confidence: average(confidences),
+			Speaker:    current[0].Speaker,
+		})
+		current = nil
+	}
+	for _, t := range ts {
+		for _, tw := range words(t) {
+			w := word{Word: tw, confidence: t.Confidence}
+			if w.Speaker == 0 {
+				w.Speaker = t.Speaker
+			}
+			// French typography separates some punctuation from the previous word.
+			if isPunctuation(w.Text) && len(current) > 0 {
+				last := &current[len(current)-1]
+				last.Text += " " + w.Text
+				last.End = w.End
+			} else {
+				if len(current) > 0 && current[0].Speaker != w.Speaker {
+					flush()
+				}
+				current = append(current, w)
+			}
+			if endsSentence(current[len(current)-1].Text, abbrevs) || len(current) >= maxWords {
+				flush()
+			}
+		}
+	}
+	flush()
+	return sentences
+}
+
+// words returns the words of a transcription with their offsets, estimated if unknown.
+func words(t transcribe.Transcription) []transcribe.Word {
+	if len(t.Words) > 0 {
+		return t.Words
+	}
+	fields := strings.Fields(t.Text)
+	end := t.End
+	if end < t.Start {
+		end = t.Start
+	}
+	ws := make([]transcribe.Word, 0, len(fields))
+	for i, f := range fields {
+		ws = append(ws, transcribe.Word{
+			Text:  f,
+			Start: t.Start + (end-t.Start)*time.Duration(i)/time.Duration(len(fields)),
+			End:   t.Start + (end-t.Start)*time.Duration(i+1)/time.Duration(len(fields)),
+		})
+	}
+	return ws
+}
+
+// average returns the average of the known confidences, 0 if none is known.
+func average(confidences []float32) float32 {
+	var sum float32
+	n := 0
+	for _, c := range confidences {
+		if c > 0 {
+			sum += c
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float32(n)
+}
+
+func isPunctuation(word string) bool {
+	for _, r := range word {
+		if !unicode.IsPunct(r) {
+			return false
+		}
+	}
+	return word != ""
+}
+
+// endsSentence returns whether the word, with its trailing punctuation, ends a sentence.
+func endsSentence(word string, abbrevs map[string]bool) bool {
+	w := strings.TrimRight(word, `"»)’'`)
+	w = strings.TrimSpace(w)
+	switch {
+	case strings.HasSuffix(w, "?"), strings.HasSuffix(w, "!"), strings.HasSuffix(w, "…"), strings.HasSuffix(w, "..."):
+		return true
+	case !strings.HasSuffix(w, "."):
+		return false
+	}
+	last := strings.ToLower(w[strings.LastIndex(w, " ")+1:])
+	if abbrevs[last] {
+		return false
+	}
+	// Initials such as the J. of J. Doe.
+	letters := []rune(strings.TrimSuffix(last, "."))
+	return !(len(letters) == 1 && unicode.IsLetter(letters[0]))
+}
+
+// Windows groups consecutive sentences by size, each window starting with the last overlap sentences of the previous one
+// so that a search matching words across two windows still finds them together with their context.
+// Windows do not span several speakers.
+func Windows(sentences []Sentence, size, overlap int) []Sentence {
+	windows := make([]Sentence, 0)
+	start := 0
+	for i := range sentences {
+		if i+1 == len(sentences) || sentences[i+1].Speaker != sentences[start].Speaker {
+			windows = append(windows, windowsOf(sentences[start:i+1], size, overlap)...)
+			start = i + 1
+		}
+	}
+	return windows
+}
+
+// windowsOf groups the sentences of a single speaker.
+func windowsOf(sentences []Sentence, size, overlap int) []Sentence {
+	if size < 1 {
+		size = 1
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = size - 1
+	}
+	windows := make([]Sentence, 0)
+	for start := 0; start < len(sentences); start += size - overlap {
+		end := start + size
+		if end > len(sentences) {
+			end = len(sentences)
+		}
+		texts := make([]string, 0, end-start)
+		confidences := make([]float32, 0, end-start)
+		for _, s := range sentences[start:end] {
+			texts = append(texts, s.Text)
+			confidences = append(confidences, s.Confidence)
+		}
+		windows = append(windows, Sentence{
+			Text:       strings.Join(texts, " "),
+			Start:      sentences[start].Start,
+			End:        sentences[end-1].End,
+			Confidence: average(confidences),
+			Speaker:    sentences[start].Speaker,
+		})
+		if end == len(sentences) {
+			break
+		}
+	}
+	return windows
+}
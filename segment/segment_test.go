@@ -0,0 +1,140 @@
+package segment
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/attwad/cdf/transcribe"
+)
+
+// timedWords returns the words of text, each lasting a second from start.
+func timedWords(text string, start time.Duration) []transcribe.Word {
+	words := make([]transcribe.Word, 0)
+	for i, w := range strings.Fields(text) {
+		words = append(words, transcribe.Word{
+			Text:  w,
+			Start: start + time.Duration(i)*time.Second,
+			End:   start + time.Duration(i+1)*time.Second,
+		})
+	}
+	return words
+}
+
+func TestSplit(t *testing.T) {
+	var tests = []struct {
+		msg  string
+		lang string
+		ts   []transcribe.Transcription
+		want string
+	}{
+		{
+			msg:  "punctuation with word offsets",
+			lang: "en-US",
+			ts: []transcribe.Transcription{
+				{Words: timedWords("Mr. Doe talked about opium. Was it traded? Yes", 0)},
+			},
+			want: "[{Mr. Doe talked about opium. 0s 5s 0 0} {Was it traded? 5s 8s 0 0} {Yes 8s 9s 0 0}]",
+		}, {
+			msg:  "french spacing and abbreviations",
+			lang: "fr",
+			ts: []transcribe.Transcription{
+				{Words: timedWords("M. Doe et J. Dupont en parlent , vraiment ? Oui.", 0)},
+			},
+			want: "[{M. Doe et J. Dupont en parlent , vraiment ? 0s 10s 0 0} {Oui. 10s 11s 0 0}]",
+		}, {
+			msg:  "sentence across transcriptions",
+			lang: "fr",
+			ts: []transcribe.Transcription{
+				{Words: timedWords("Le commerce", 0)},
+				{Words: timedWords("de l'opium. Ensuite", 10*time.Second)},
+			},
+			want: "[{Le commerce de l'opium. 0s 12s 0 0} {Ensuite 12s 13s 0 0}]",
+		}, {
+			msg:  "no word offsets",
+			lang: "fr",
+			ts: []transcribe.Transcription{
+				{Text: "Une phrase. Une autre", Start: 10 * time.Second, End: 14 * time.Second},
+			},
+			want: "[{Une phrase. 10s 12s 0 0} {Une autre 12s 14s 0 0}]",
+		}, {
+			msg:  "speaker turns",
+			lang: "fr",
+			ts: []transcribe.Transcription{
+				{Words: timedWords("Merci Jean", 0), Speaker: 1},
+				{Text: "Je commence.", Start: 2 * time.Second, End: 4 * time.Second, Speaker: 2},
+			},
+			want: "[{Merci Jean 0s 2s 0 1} {Je commence. 2s 4s 0 2}]",
+		},
+	}
+	for _, test := range tests {
+		if got, want := fmt.Sprint(Split(test.lang, test.ts)), test.want; got != want {
+			t.Errorf("[%s] sentences got=%s, want=%s", test.msg, got, want)
+		}
+	}
+}
+
+func TestSplitCutsLongSentences(t *testing.T) {
+	text := strings.Repeat("mot ", maxWords+10)
+	sentences := Split("fr", []transcribe.Transcription{{Text: text, End: time.Minute}})
+	if got, want := len(sentences), 2; got != want {
+		t.Fatalf("num sentences got=%d, want=%d", got, want)
+	}
+	if got, want := len(strings.Fields(sentences[0].Text)), maxWords; got != want {
+		t.Errorf("num words in first sentence got=%d, want=%d", got, want)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	sentences := make([]Sentence, 0)
+	for i := 0; i < 5; i++ {
+		sentences = append(sentences, Sentence{Text: fmt.Sprint(i), Start: time.Duration(i) * time.Second, End: time.Duration(i+1) * time.Second})
+	}
+	var tests = []struct {
+		size    int
+		overlap int
+		want    string
+	}{
+		{1, 0, "[{0 0s 1s 0 0} {1 1s 2s 0 0} {2 2s 3s 0 0} {3 3s 4s 0 0} {4 4s 5s 0 0}]"},
+		{3, 1, "[{0 1 2 0s 3s 0 0} {2 3 4 2s 5s 0 0}]"},
+		{2, 1, "[{0 1 0s 2s 0 0} {1 2 1s 3s 0 0} {2 3 2s 4s 0 0} {3 4 3s 5s 0 0}]"},
+		{10, 2, "[{0 1 2 3 4 0s 5s 0 0}]"},
+	}
+	for _, test := range tests {
+		if got, want := fmt.Sprint(Windows(sentences, test.size, test.overlap)), test.want; got != want {
+			t.Errorf("[%d/%d] windows got=%s, want=%s", test.size, test.overlap, got, want)
+		}
+	}
+	sentences[3].Speaker, sentences[4].Speaker = 2, 2
+	if got, want := fmt.Sprint(Windows(sentences, 3, 1)), "[{0 1 2 0s 3s 0 0} {3 4 3s 5s 0 2}]"; got != want {
+		t.Errorf("windows of two speakers got=%s, want=%s", got, want)
+	}
+	if got := Windows(nil, 3, 1); len(got) != 0 {
+		t.Errorf("windows of no sentences got=%v, want none", got)
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	ts := []transcribe.Transcription{
+		{Words: timedWords("Bonjour à", 0), Confidence: 0.9},
+		{Words: timedWords("tous. Commençons.", 2*time.Second), Confidence: 0.3},
+		{Words: timedWords("Inconnu.", 4*time.Second)},
+	}
+	sentences := Split("fr", ts)
+	var tests = []struct {
+		msg  string
+		got  float32
+		want float32
+	}{
+		{"words of two transcriptions", sentences[0].Confidence, 0.7},
+		{"single transcription", sentences[1].Confidence, 0.3},
+		{"unknown", sentences[2].Confidence, 0},
+		{"window", Windows(sentences, 3, 1)[0].Confidence, 0.5},
+	}
+	for _, test := range tests {
+		if diff := test.got - test.want; diff > 0.001 || diff < -0.001 {
+			t.Errorf("[%s] confidence got=%f, want=%f", test.msg, test.got, test.want)
+		}
+	}
+}
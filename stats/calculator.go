@@ -30,7 +30,7 @@ func main() {
 	cursor := ""
 	for {
 		log.Println("Fetching new lessons...")
-		lessons, nextCursor, err := d.GetLessons(ctx, cursor, db.FilterNone, pageSize)
+		lessons, nextCursor, err := d.GetLessons(ctx, cursor, db.Filter{}, pageSize)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,127 @@
+// Package subtitle turns transcripts into WebVTT and SRT subtitle files.
+package subtitle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/attwad/cdf/artifact"
+	"github.com/attwad/cdf/segment"
+	"github.com/attwad/cdf/upload"
+)
+
+// Captions are cut after maxChars characters, and shown on two lines if longer than maxLineChars.
+const (
+	maxChars     = 84
+	maxLineChars = 42
+)
+
+// Caption is a piece of transcript shown as a subtitle while it is said.
+type Caption struct {
+	// Start and End are offsets from the beginning of the audio.
+	Start time.Duration
+	End   time.Duration
+	// Text may span several lines.
+	Text string
+}
+
+// Captions cuts sentences into captions short enough to be read while they are said, offset is added to their times.
+// Long sentences are cut between words, the time of each caption is proportional to its length.
+func Captions(sentences []segment.Sentence, offset time.Duration) []Caption {
+	captions := make([]Caption, 0, len(sentences))
+	for _, s := range sentences {
+		length := len(s.Text)
+		if length == 0 {
+			continue
+		}
+		at := func(pos int) time.Duration {
+			return offset + s.Start + (s.End-s.Start)*time.Duration(pos)/time.Duration(length)
+		}
+		pos := 0
+		for _, text := range cut(strings.Fields(s.Text), maxChars) {
+			captions = append(captions, Caption{
+				Start: at(pos),
+				End:   at(pos + len(text)),
+				Text:  strings.Join(cut(strings.Fields(text), maxLineChars), "\n"),
+			})
+			// Words are separated by a single space.
+			pos += len(text) + 1
+		}
+	}
+	return captions
+}
+
+// cut joins words into lines of at most max characters, longer words are kept on their own line.
+func cut(words []string, max int) []string {
+	lines := make([]string, 0)
+	line := ""
+	for _, w := range words {
+		if line != "" && len(line)+1+len(w) > max {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += w
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// WriteWebVTT writes the captions in the WebVTT format.
+func WriteWebVTT(w io.Writer, captions []Caption) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	for _, c := range captions {
+		if _, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n", timestamp(c.Start, "."), timestamp(c.End, "."), escaper.Replace(c.Text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSRT writes the captions in the SubRip format.
+func WriteSRT(w io.Writer, captions []Caption) error {
+	for i, c := range captions {
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, timestamp(c.Start, ","), timestamp(c.End, ","), c.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timestamp formats d as hh:mm:ss followed by the milliseconds after the given separator.
+func timestamp(d time.Duration, sep string) string {
+	ms := int64(d / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// Upload uploads the captions as WebVTT and SRT files named after the media they subtitle,
+// next to the text transcript of the media. Returns the names of the files.
+func Upload(ctx context.Context, u upload.FileUploader, mediaLink string, captions []Caption) ([]string, error) {
+	names := make([]string, 0)
+	for _, f := range []struct {
+		ext   string
+		write func(io.Writer, []Caption) error
+	}{{".vtt", WriteWebVTT}, {".srt", WriteSRT}} {
+		var b bytes.Buffer
+		if err := f.write(&b, captions); err != nil {
+			return nil, err
+		}
+		name := artifact.SubtitleName(mediaLink, f.ext)
+		if err := u.UploadFile(ctx, &b, name); err != nil {
+			return nil, fmt.Errorf("uploading %s subtitles: %v", f.ext, err)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
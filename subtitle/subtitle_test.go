@@ -0,0 +1,78 @@
+package subtitle
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/attwad/cdf/segment"
+)
+
+func TestCaptions(t *testing.T) {
+	long := strings.TrimSpace(strings.Repeat("abcdefghi ", 12))
+	sentences := []segment.Sentence{
+		{Text: "Bonjour à tous.", Start: time.Second, End: 2 * time.Second},
+		{Text: "", Start: 2 * time.Second, End: 3 * time.Second},
+		{Text: long, Start: 10 * time.Second, End: 22 * time.Second},
+	}
+	captions := Captions(sentences, time.Hour)
+	if got, want := len(captions), 3; got != want {
+		t.Fatalf("num captions got=%d, want=%d: %v", got, want, captions)
+	}
+	var tests = []struct {
+		msg       string
+		got, want Caption
+	}{
+		{
+			msg:  "short sentence",
+			got:  captions[0],
+			want: Caption{Start: time.Hour + time.Second, End: time.Hour + 2*time.Second, Text: "Bonjour à tous."},
+		}, {
+			// 8 words of 9 characters and 7 spaces are 79 characters of the 119 of the sentence.
+			msg:  "first part of a long sentence",
+			got:  captions[1],
+			want: Caption{Start: time.Hour + 10*time.Second, End: time.Hour + 10*time.Second + 12*time.Second*79/119, Text: strings.Repeat("abcdefghi ", 3) + "abcdefghi\n" + strings.TrimSpace(strings.Repeat("abcdefghi ", 4))},
+		}, {
+			msg:  "end of a long sentence",
+			got:  captions[2],
+			want: Caption{Start: time.Hour + 10*time.Second + 12*time.Second*80/119, End: time.Hour + 22*time.Second, Text: strings.TrimSpace(strings.Repeat("abcdefghi ", 4))},
+		},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("[%s] caption got=%+v, want=%+v", test.msg, test.got, test.want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	captions := []Caption{
+		{Start: 1500 * time.Millisecond, End: 3 * time.Second, Text: "Fish & <chips>"},
+		{Start: time.Hour + 61*time.Second, End: time.Hour + 62*time.Second + time.Millisecond, Text: "two\nlines"},
+	}
+	var tests = []struct {
+		format string
+		write  func(*bytes.Buffer) error
+		want   string
+	}{
+		{
+			format: "webvtt",
+			write:  func(b *bytes.Buffer) error { return WriteWebVTT(b, captions) },
+			want:   "WEBVTT\n\n00:00:01.500 --> 00:00:03.000\nFish &amp; &lt;chips&gt;\n\n01:01:01.000 --> 01:01:02.001\ntwo\nlines\n\n",
+		}, {
+			format: "srt",
+			write:  func(b *bytes.Buffer) error { return WriteSRT(b, captions) },
+			want:   "1\n00:00:01,500 --> 00:00:03,000\nFish & <chips>\n\n2\n01:01:01,000 --> 01:01:02,001\ntwo\nlines\n\n",
+		},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := test.write(&b); err != nil {
+			t.Fatalf("[%s] write: %v", test.format, err)
+		}
+		if got, want := b.String(), test.want; got != want {
+			t.Errorf("[%s] got=%q, want=%q", test.format, got, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package main uploads WebVTT and SRT subtitles for the courses converted before the worker made them.
+// The full transcripts in datastore do not keep when words are said, the times of the subtitles are estimated
+// assuming the words are evenly spread over the duration of each course. Courses that already have subtitles, or a
+// manifest written by a worker that made them, are skipped so that precise subtitles are not overwritten.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/attwad/cdf/artifact"
+	"github.com/attwad/cdf/db"
+	"github.com/attwad/cdf/segment"
+	"github.com/attwad/cdf/subtitle"
+	"github.com/attwad/cdf/transcribe"
+	"github.com/attwad/cdf/upload"
+)
+
+const pageSize = 100
+
+var (
+	projectID = flag.String("project_id", "college-de-france", "Cloud project ID")
+	bucket    = flag.String("bucket", "", "Cloud storage bucket")
+	storage   = flag.String("storage", "gcs", "Where to store files: \"gcs\" to use --bucket or \"local:/some/dir\" to use a local directory")
+	chaire    = flag.String("chaire", "", "Only backfill the courses of this chaire if not empty")
+)
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+	u, err := upload.NewFileUploader(ctx, *storage, *bucket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := db.NewDatastoreWrapper(ctx, *projectID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter := db.Filter{Converted: db.Bool(true), Chaire: *chaire}
+	cursor := ""
+	numUploaded := 0
+	for {
+		lessons, nextCursor, err := d.GetLessons(ctx, cursor, filter, pageSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cursor = nextCursor
+		if len(lessons) == 0 {
+			break
+		}
+		for _, lesson := range lessons {
+			if lesson.DurationSec == 0 || lesson.MediaLink() == "" {
+				log.Println("Skipping", lesson.Source, "which has no duration or media")
+				continue
+			}
+			has, err := hasSubtitles(ctx, u, lesson.MediaLink())
+			if err != nil {
+				log.Fatal(err)
+			}
+			if has {
+				log.Println("Skipping", lesson.Source, "which already has subtitles")
+				continue
+			}
+			t := transcribe.Transcription{Text: lesson.Transcript, End: time.Duration(lesson.DurationSec) * time.Second}
+			captions := subtitle.Captions(segment.Split(lesson.Language, []transcribe.Transcription{t}), 0)
+			if _, err := subtitle.Upload(ctx, u, lesson.MediaLink(), captions); err != nil {
+				log.Fatalf("Uploading subtitles of %s: %v", lesson.Source, err)
+			}
+			numUploaded++
+		}
+		log.Println("Uploaded subtitles of", numUploaded, "lessons")
+	}
+}
+
+// hasSubtitles returns whether the course has subtitles or a manifest, which the worker writes with its subtitles.
+func hasSubtitles(ctx context.Context, u upload.FileUploader, mediaLink string) (bool, error) {
+	for _, name := range []string{artifact.SubtitleName(mediaLink, ".vtt"), artifact.ManifestName(mediaLink)} {
+		r, err := u.Download(ctx, name)
+		if err == upload.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("checking %s: %v", name, err)
+		}
+		r.Close()
+		return true, nil
+	}
+	return false, nil
+}
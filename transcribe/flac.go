@@ -0,0 +1,36 @@
+package transcribe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FLACDuration returns the duration of the FLAC stream read from r from its STREAMINFO metadata block,
+// which the FLAC format requires to be the first one.
+func FLACDuration(r io.Reader) (time.Duration, error) {
+	// 4 bytes of magic, 4 bytes of metadata block header and 34 bytes of STREAMINFO.
+	b := make([]byte, 42)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, fmt.Errorf("reading FLAC header: %v", err)
+	}
+	if !bytes.Equal(b[:4], []byte("fLaC")) {
+		return 0, errors.New("not a FLAC stream")
+	}
+	if blockType := b[4] & 0x7F; blockType != 0 {
+		return 0, fmt.Errorf("first metadata block is of type %d, not STREAMINFO", blockType)
+	}
+	info := b[8:]
+	// 20 bits of sample rate, 3 bits of channels, 5 bits of bits per sample then 36 bits of total samples.
+	sampleRate := uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+	totalSamples := uint64(info[13]&0x0F)<<32 | uint64(info[14])<<24 | uint64(info[15])<<16 | uint64(info[16])<<8 | uint64(info[17])
+	if sampleRate == 0 {
+		return 0, errors.New("invalid FLAC sample rate 0")
+	}
+	if totalSamples == 0 {
+		return 0, errors.New("FLAC total number of samples is unknown")
+	}
+	return time.Duration(totalSamples * uint64(time.Second) / sampleRate), nil
+}
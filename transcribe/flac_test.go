@@ -0,0 +1,52 @@
+package transcribe
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// flacHeader returns the start of a FLAC stream with the given sample rate and total number of samples.
+func flacHeader(sampleRate, totalSamples uint64) []byte {
+	b := []byte("fLaC")
+	// Last metadata block flag, STREAMINFO type and length of 34 bytes.
+	b = append(b, 0x80, 0x00, 0x00, 0x22)
+	info := make([]byte, 34)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	// Mono (0) and 16 bits per sample (15) are stored after the sample rate.
+	info[12] = byte(sampleRate << 4)
+	info[13] = 0xF0 | byte(totalSamples>>32)
+	info[14] = byte(totalSamples >> 24)
+	info[15] = byte(totalSamples >> 16)
+	info[16] = byte(totalSamples >> 8)
+	info[17] = byte(totalSamples)
+	return append(b, info...)
+}
+
+func TestFLACDuration(t *testing.T) {
+	got, err := FLACDuration(bytes.NewReader(flacHeader(16000, 16000*3600+8000)))
+	if err != nil {
+		t.Fatalf("FLACDuration: %v", err)
+	}
+	if want := time.Hour + 500*time.Millisecond; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+}
+
+func TestFLACDurationFails(t *testing.T) {
+	tests := []struct {
+		msg   string
+		input []byte
+	}{
+		{"too short", []byte("fLaC")},
+		{"not flac", append([]byte("OggS"), make([]byte, 38)...)},
+		{"no sample rate", flacHeader(0, 1000)},
+		{"unknown length", flacHeader(16000, 0)},
+	}
+	for _, test := range tests {
+		if _, err := FLACDuration(bytes.NewReader(test.input)); err == nil {
+			t.Errorf("[%s] wanted error, got nil", test.msg)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package transcribe
+
+import (
+	"bytes"
+	"mime"
+)
+
+// Format is the container format of an audio or video file.
+type Format string
+
+// Formats of the files published by the College de France and other sources.
+const (
+	FormatUnknown Format = ""
+	FormatMP3     Format = "mp3"
+	FormatM4A     Format = "m4a"
+	FormatMP4     Format = "mp4"
+	FormatOGG     Format = "ogg"
+	FormatFLAC    Format = "flac"
+	FormatWAV     Format = "wav"
+)
+
+var contentTypeFormats = map[string]Format{
+	"audio/mpeg":   FormatMP3,
+	"audio/mp3":    FormatMP3,
+	"audio/mp4":    FormatM4A,
+	"audio/x-m4a":  FormatM4A,
+	"audio/aac":    FormatM4A,
+	"video/mp4":    FormatMP4,
+	"audio/ogg":    FormatOGG,
+	"video/ogg":    FormatOGG,
+	"audio/flac":   FormatFLAC,
+	"audio/x-flac": FormatFLAC,
+	"audio/wav":    FormatWAV,
+	"audio/wave":   FormatWAV,
+	"audio/x-wav":  FormatWAV,
+}
+
+// DetectFormat guesses the format of a file from its first bytes, falling back to its HTTP Content-Type.
+// Magic bytes are preferred as servers often send a generic or wrong Content-Type.
+func DetectFormat(contentType string, header []byte) Format {
+	switch {
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return FormatMP3
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		// MPEG frame sync, a zero layer means AAC in an ADTS stream rather than MP3.
+		if (header[1]>>1)&0x03 == 0 {
+			return FormatM4A
+		}
+		return FormatMP3
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return FormatOGG
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return FormatFLAC
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		return FormatWAV
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		if bytes.HasPrefix(header[8:12], []byte("M4A")) {
+			return FormatM4A
+		}
+		return FormatMP4
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return FormatUnknown
+	}
+	return contentTypeFormats[mediaType]
+}
+
+// soxSupported returns whether sox can read the format without extra plugins.
+func (f Format) soxSupported() bool {
+	switch f {
+	case FormatMP3, FormatOGG, FormatFLAC, FormatWAV:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package transcribe
+
+import "testing"
+
+func TestDetectFormat(t *testing.T) {
+	var tests = []struct {
+		msg         string
+		contentType string
+		header      []byte
+		want        Format
+	}{
+		{"mp3 with id3 tag", "application/octet-stream", []byte("ID3\x04\x00"), FormatMP3},
+		{"mp3 frame", "", []byte{0xFF, 0xFB, 0x90, 0x64}, FormatMP3},
+		{"aac adts", "", []byte{0xFF, 0xF1, 0x50, 0x80}, FormatM4A},
+		{"ogg", "", []byte("OggS\x00\x02"), FormatOGG},
+		{"flac", "", []byte("fLaC\x00\x00"), FormatFLAC},
+		{"wav", "", []byte("RIFF\x24\x08\x00\x00WAVEfmt "), FormatWAV},
+		{"m4a", "", []byte("\x00\x00\x00\x20ftypM4A \x00\x00"), FormatM4A},
+		{"mp4", "", []byte("\x00\x00\x00\x20ftypisom\x00\x00"), FormatMP4},
+		{"magic wins over content type", "audio/mpeg", []byte("OggS\x00\x02"), FormatOGG},
+		{"content type fallback", "audio/x-m4a; charset=binary", []byte("garbage"), FormatM4A},
+		{"unknown", "text/html", []byte("<html>"), FormatUnknown},
+		{"bad content type", ";;;", nil, FormatUnknown},
+	}
+	for _, test := range tests {
+		if got := DetectFormat(test.contentType, test.header); got != test.want {
+			t.Errorf("[%s] got=%q, want=%q", test.msg, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
+)
+
+// Audio up to syncMaxDuration and inlineMaxBytes is sent inline to the synchronous API, audio up to
+// streamingMaxDuration is streamed, longer audio must be uploaded for a long running recognition.
+const (
+	syncMaxDuration      = time.Minute
+	inlineMaxBytes       = 10 << 20
+	streamingMaxDuration = 5 * time.Minute
+	// streamingChunkBytes is the size of the audio sent by each streaming request.
+	streamingChunkBytes = 16 << 10
+)
+
+// localMode is how audio is sent to the speech recognition without being uploaded.
+type localMode int
+
+const (
+	localNone localMode = iota
+	localSync
+	localStreaming
+)
+
+// chooseLocalMode returns how audio of the given duration and size can be sent without being uploaded.
+func chooseLocalMode(d time.Duration, size int) localMode {
+	switch {
+	case d <= syncMaxDuration && size <= inlineMaxBytes:
+		return localSync
+	case d <= streamingMaxDuration:
+		return localStreaming
+	}
+	return localNone
+}
+
+func (g *gSpeechTranscriber) CanTranscribeLocal(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	d, err := FLACDuration(f)
+	if err != nil {
+		return false, err
+	}
+	return chooseLocalMode(d, int(fi.Size())) != localNone, nil
+}
+
+func (g *gSpeechTranscriber) TranscribeLocal(ctx context.Context, path string, opts Options) ([]Transcription, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := FLACDuration(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	switch chooseLocalMode(d, len(content)) {
+	case localSync:
+		return g.recognize(ctx, content, opts)
+	case localStreaming:
+		return g.stream(ctx, content, opts)
+	}
+	return nil, fmt.Errorf("%s lasts %s, it must be uploaded to be transcribed", path, d)
+}
+
+// recognize transcribes audio with a synchronous request.
+func (g *gSpeechTranscriber) recognize(ctx context.Context, content []byte, opts Options) ([]Transcription, error) {
+	req := &speechpb.RecognizeRequest{
+		Config: recognitionConfig(opts),
+		Audio: &speechpb.RecognitionAudio{
+			AudioSource: &speechpb.RecognitionAudio_Content{Content: content},
+		},
+	}
+	log.Println("Sending synchronous gspeech request for", len(content), "bytes of audio")
+	resp, err := g.client.Recognize(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return toTranscriptions(resp.Results), nil
+}
+
+// stream transcribes audio with streaming requests, only the final results are kept.
+func (g *gSpeechTranscriber) stream(ctx context.Context, content []byte, opts Options) ([]Transcription, error) {
+	stream, err := g.client.StreamingRecognize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Streaming", len(content), "bytes of audio to gspeech")
+	if err := stream.Send(&speechpb.StreamingRecognizeRequest{
+		StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
+			StreamingConfig: &speechpb.StreamingRecognitionConfig{Config: recognitionConfig(opts)},
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("sending streaming config: %v", err)
+	}
+	// Audio is sent while results are received so that neither side blocks the other.
+	sent := make(chan error, 1)
+	go func() {
+		for len(content) > 0 {
+			n := streamingChunkBytes
+			if n > len(content) {
+				n = len(content)
+			}
+			if err := stream.Send(&speechpb.StreamingRecognizeRequest{
+				StreamingRequest: &speechpb.StreamingRecognizeRequest_AudioContent{AudioContent: content[:n]},
+			}); err != nil {
+				sent <- err
+				return
+			}
+			content = content[n:]
+		}
+		sent <- stream.CloseSend()
+	}()
+	results := make([]*speechpb.SpeechRecognitionResult, 0)
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.GetError() != nil {
+			return nil, fmt.Errorf("received error in response: %v", resp.GetError())
+		}
+		for _, r := range resp.Results {
+			if r.IsFinal {
+				results = append(results, &speechpb.SpeechRecognitionResult{Alternatives: r.Alternatives, ChannelTag: r.ChannelTag})
+			}
+		}
+	}
+	if err := <-sent; err != nil {
+		return nil, fmt.Errorf("sending audio: %v", err)
+	}
+	return toTranscriptions(results), nil
+}
@@ -0,0 +1,124 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
+	longrunningpb "google.golang.org/genproto/googleapis/longrunning"
+)
+
+// Polling configures how long running recognitions are waited for.
+type Polling struct {
+	// Interval is the time between the first polls, it doubles after each poll up to MaxInterval.
+	Interval    time.Duration
+	MaxInterval time.Duration
+	// Retries is how many transient errors in a row are retried before giving up.
+	Retries int
+	// The wait is abandoned MinDeadline plus DeadlineFactor times the duration of the audio after the operation started.
+	MinDeadline    time.Duration
+	DeadlineFactor float64
+}
+
+// DefaultPolling polls every few seconds at first, then every minute, for at most 30 minutes more than the audio lasts.
+var DefaultPolling = Polling{
+	Interval:       2 * time.Second,
+	MaxInterval:    time.Minute,
+	Retries:        5,
+	MinDeadline:    30 * time.Minute,
+	DeadlineFactor: 1,
+}
+
+// deadline returns how long the recognition of audio of the given duration may take.
+func (p Polling) deadline(audio time.Duration) time.Duration {
+	if audio <= 0 {
+		audio = ChunkDuration
+	}
+	return p.MinDeadline + time.Duration(p.DeadlineFactor*float64(audio))
+}
+
+// DeadlineError is returned when a long running operation is not done by its deadline, it has to be started again.
+type DeadlineError struct {
+	OpName   string
+	Deadline time.Duration
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("operation %s was not done within %s of its start", e.OpName, e.Deadline)
+}
+
+// poll gets the named operation until it is done, waiting longer and longer between polls.
+// Transient errors are retried, the wait is bounded by a deadline from the start of the operation derived from the
+// duration of the audio. The operation is always polled once, one that is done past its deadline is still returned.
+func poll(ctx context.Context, client longrunningpb.OperationsClient, opName string, p Polling, started time.Time, audio time.Duration) (*longrunningpb.Operation, error) {
+	deadline := p.deadline(audio)
+	expires := started.Add(deadline)
+	interval := p.Interval
+	failures := 0
+	lastPercent := int32(-1)
+	for {
+		op, err := client.GetOperation(ctx, &longrunningpb.GetOperationRequest{Name: opName})
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return nil, err
+		case err != nil && isTransient(err) && failures < p.Retries:
+			failures++
+			log.Printf("Getting operation %s failed (%d/%d), retrying in %s: %v", opName, failures, p.Retries, interval, err)
+		case err != nil:
+			return nil, err
+		case op.Done:
+			return op, nil
+		default:
+			failures = 0
+			if percent := progressPercent(op); percent >= 0 && percent != lastPercent {
+				log.Printf("Operation %s is %d%% done", opName, percent)
+				lastPercent = percent
+			}
+		}
+		left := time.Until(expires)
+		if left <= 0 {
+			return nil, &DeadlineError{OpName: opName, Deadline: deadline}
+		}
+		// The last poll happens at the deadline.
+		wait := interval
+		if wait > left {
+			wait = left
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		interval *= 2
+		if interval > p.MaxInterval {
+			interval = p.MaxInterval
+		}
+	}
+}
+
+// isTransient returns whether the error of a call may not happen again.
+func isTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// progressPercent returns how far along the recognition of the operation is, -1 if unknown.
+func progressPercent(op *longrunningpb.Operation) int32 {
+	if op.GetMetadata() == nil {
+		return -1
+	}
+	var m speechpb.LongRunningRecognizeMetadata
+	if err := proto.Unmarshal(op.GetMetadata().Value, &m); err != nil {
+		return -1
+	}
+	return m.GetProgressPercent()
+}
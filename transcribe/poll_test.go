@@ -0,0 +1,146 @@
+package transcribe
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	longrunningpb "google.golang.org/genproto/googleapis/longrunning"
+)
+
+// fakeOperationsClient returns its errors in order, then operations that are done after pending polls.
+// Calls fail once the context is done.
+type fakeOperationsClient struct {
+	errs    []error
+	pending int
+	calls   int
+}
+
+func (c *fakeOperationsClient) GetOperation(ctx context.Context, in *longrunningpb.GetOperationRequest, opts ...grpc.CallOption) (*longrunningpb.Operation, error) {
+	c.calls++
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	if c.pending > 0 {
+		c.pending--
+		return &longrunningpb.Operation{Name: in.Name}, nil
+	}
+	return &longrunningpb.Operation{Name: in.Name, Done: true}, nil
+}
+
+func TestPoll(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection reset")
+	var tests = []struct {
+		name      string
+		client    *fakeOperationsClient
+		wantCalls int
+		wantErr   string
+	}{
+		{
+			name:      "done after pending polls",
+			client:    &fakeOperationsClient{pending: 2},
+			wantCalls: 3,
+		}, {
+			name:      "transient errors are retried",
+			client:    &fakeOperationsClient{errs: []error{unavailable, status.Error(codes.DeadlineExceeded, "slow")}},
+			wantCalls: 3,
+		}, {
+			name:      "too many transient errors",
+			client:    &fakeOperationsClient{errs: []error{unavailable, unavailable, unavailable, unavailable}},
+			wantCalls: 4,
+			wantErr:   "connection reset",
+		}, {
+			name:      "other errors are not retried",
+			client:    &fakeOperationsClient{errs: []error{errors.New("permission denied")}},
+			wantCalls: 1,
+			wantErr:   "permission denied",
+		},
+	}
+	p := Polling{Interval: time.Millisecond, MaxInterval: 2 * time.Millisecond, Retries: 3, MinDeadline: time.Minute}
+	for _, test := range tests {
+		op, err := poll(context.Background(), test.client, "op", p, time.Now(), time.Minute)
+		if got, want := test.client.calls, test.wantCalls; got != want {
+			t.Errorf("[%s] calls got=%d, want=%d", test.name, got, want)
+		}
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("[%s] error got=%v, want containing %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] poll: %v", test.name, err)
+			continue
+		}
+		if !op.Done {
+			t.Errorf("[%s] operation is not done", test.name)
+		}
+	}
+}
+
+func TestPollDeadline(t *testing.T) {
+	p := Polling{Interval: time.Millisecond, MaxInterval: time.Millisecond, Retries: 3, MinDeadline: 20 * time.Millisecond}
+	var tests = []struct {
+		msg      string
+		started  time.Time
+		pending  int
+		maxCalls int
+		wantDone bool
+	}{
+		{msg: "started now", started: time.Now(), pending: 1000, maxCalls: 999},
+		{msg: "done after the deadline", started: time.Now().Add(-time.Hour), pending: 0, maxCalls: 1, wantDone: true},
+		{msg: "not done after the deadline", started: time.Now().Add(-time.Hour), pending: 1000, maxCalls: 1},
+	}
+	for _, test := range tests {
+		client := &fakeOperationsClient{pending: test.pending}
+		op, err := poll(context.Background(), client, "op", p, test.started, time.Minute)
+		if client.calls > test.maxCalls {
+			t.Errorf("[%s] calls got=%d, want at most %d", test.msg, client.calls, test.maxCalls)
+		}
+		if test.wantDone {
+			if err != nil || !op.Done {
+				t.Errorf("[%s] poll got op=%v err=%v, want the done operation", test.msg, op, err)
+			}
+			continue
+		}
+		if _, ok := err.(*DeadlineError); !ok {
+			t.Errorf("[%s] poll got err=%v, want a DeadlineError", test.msg, err)
+		}
+	}
+}
+
+func TestPollCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client := &fakeOperationsClient{}
+	if _, err := poll(ctx, client, "op", DefaultPolling, time.Now(), time.Minute); err != context.Canceled {
+		t.Errorf("poll with a canceled context got err=%v, want=%v", err, context.Canceled)
+	}
+}
+
+func TestPollingDeadline(t *testing.T) {
+	p := Polling{MinDeadline: 10 * time.Minute, DeadlineFactor: 0.5}
+	var tests = []struct {
+		audio time.Duration
+		want  time.Duration
+	}{
+		{audio: time.Hour, want: 40 * time.Minute},
+		{audio: 0, want: 10*time.Minute + ChunkDuration/2},
+	}
+	for _, test := range tests {
+		if got := p.deadline(test.audio); got != test.want {
+			t.Errorf("[%s] deadline got=%s, want=%s", test.audio, got, test.want)
+		}
+	}
+}
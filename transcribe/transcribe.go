@@ -7,6 +7,8 @@ import (
 	"log"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -18,71 +20,214 @@ import (
 	longrunningpb "google.golang.org/genproto/googleapis/longrunning"
 )
 
+// ChunkDuration is the maximum duration of a single FLAC file produced by ConvertToFLAC.
+// GCP Speech API supports max 3H chunks, 10790s = 2.99 hours.
+const ChunkDuration = 10790 * time.Second
+
 // Transcription contains what was said with a given confidence score for the overall transcription.
 type Transcription struct {
-	Text       string
-	confidence float32
+	Text string
+	// Start and End are offsets from the beginning of the audio file.
+	Start time.Duration
+	End   time.Duration
+	// Words are the individual words of Text with their own offsets, if known.
+	Words []Word
+	// Confidence is how sure the speech recognition is of Text, between 0 and 1, 0 if unknown.
+	Confidence float32
+	// Speaker distinguishes the speakers of a diarized transcription, starting at 1, 0 if unknown.
+	Speaker int
+}
+
+// Word is a single recognized word and when it was said.
+type Word struct {
+	Text  string
+	Start time.Duration
+	End   time.Duration
+	// Speaker is the speaker of the word, 0 if unknown.
+	Speaker int
+}
+
+// Options are the settings of a transcription.
+type Options struct {
+	// Language of the audio, French if undefined.
+	Language string
+	// Hints are sentences or words to help speech recognition.
+	Hints []string
+	// Diarization tells the speakers apart, for audio with several speakers.
+	// MaxSpeakers is how many speakers there may be, the speech recognition decides if zero.
+	Diarization bool
+	MaxSpeakers int
+}
+
+// DefaultConvertTimeout leaves ffmpeg time to decode the video of a long course.
+const DefaultConvertTimeout = 30 * time.Minute
+
+// Tools are the paths of the programs used to convert audio files.
+type Tools struct {
+	SoxPath string
+	// FFmpegPath is used for formats sox cannot read, like m4a and mp4 videos.
+	FFmpegPath string
+	// ConvertTimeout bounds the conversion of a file, there is no bound if zero.
+	ConvertTimeout time.Duration
 }
 
 // Transcriber allows transcription of an audio file.
 type Transcriber interface {
-	Transcribe(ctx context.Context, lang, path string, hints []string) ([]Transcription, error)
-	ConvertToFLAC(ctx context.Context, soxPath, input string) ([]string, error)
+	Transcribe(ctx context.Context, path string, opts Options) ([]Transcription, error)
+	ConvertToFLAC(ctx context.Context, tools Tools, input string, format Format) ([]string, error)
+}
+
+// ResumableTranscriber is a Transcriber whose transcriptions run as long running operations
+// that can be re-attached to by name, for example after a restart.
+type ResumableTranscriber interface {
+	Transcriber
+	// Start starts transcribing the audio file and returns the name of the operation.
+	Start(ctx context.Context, path string, opts Options) (string, error)
+	// Resume waits for the named operation to be done and returns its transcriptions.
+	// The operation was started at the given time to transcribe audio of the given duration, ChunkDuration if zero,
+	// it is given up after a deadline derived from them however many times it is resumed.
+	Resume(ctx context.Context, opName string, started time.Time, audio time.Duration) ([]Transcription, error)
+}
+
+// LocalTranscriber is a Transcriber that can transcribe some FLAC files directly from the local disk,
+// without the round trip through the storage bucket.
+type LocalTranscriber interface {
+	Transcriber
+	// CanTranscribeLocal returns whether the local FLAC file can be transcribed by TranscribeLocal.
+	CanTranscribeLocal(path string) (bool, error)
+	// TranscribeLocal transcribes a local FLAC file.
+	TranscribeLocal(ctx context.Context, path string, opts Options) ([]Transcription, error)
 }
 
 type gSpeechTranscriber struct {
-	client *speech.Client
+	client  *speech.Client
+	polling Polling
 }
 
 // NewGSpeechTranscriber creates a new transcriber using the Google Speech API.
-func NewGSpeechTranscriber(ctx context.Context) (Transcriber, error) {
+// Long running recognitions are polled as configured by polling.
+func NewGSpeechTranscriber(ctx context.Context, polling Polling) (Transcriber, error) {
 	client, err := speech.NewClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return &gSpeechTranscriber{
-		client: client,
+		client:  client,
+		polling: polling,
 	}, nil
 }
 
-func (g *gSpeechTranscriber) Transcribe(ctx context.Context, lang, gcsURI string, hints []string) ([]Transcription, error) {
-	opName, err := g.sendGCS(ctx, lang, gcsURI, hints)
+func (g *gSpeechTranscriber) Transcribe(ctx context.Context, gcsURI string, opts Options) ([]Transcription, error) {
+	started := time.Now()
+	opName, err := g.Start(ctx, gcsURI, opts)
 	if err != nil {
 		return nil, err
 	}
+	return g.Resume(ctx, opName, started, 0)
+}
+
+func (g *gSpeechTranscriber) Start(ctx context.Context, gcsURI string, opts Options) (string, error) {
+	return g.sendGCS(ctx, gcsURI, opts)
+}
 
-	resp, err := g.wait(ctx, opName)
+func (g *gSpeechTranscriber) Resume(ctx context.Context, opName string, started time.Time, audio time.Duration) ([]Transcription, error) {
+	resp, err := g.wait(ctx, opName, started, audio)
 	if err != nil {
 		return nil, err
 	}
+	return toTranscriptions(resp.Results), nil
+}
 
+// toTranscriptions keeps the most likely alternative of each result.
+// When speakers are diarized the last result repeats all the words with their speaker, it is used to tag the words
+// of the other results, which are split into one transcription per speaker turn.
+func toTranscriptions(results []*speechpb.SpeechRecognitionResult) []Transcription {
+	speakers := make([]int, 0)
+	if n := len(results); n > 1 && isDiarization(results[n-1], results[:n-1]) {
+		for _, w := range results[n-1].Alternatives[0].Words {
+			speakers = append(speakers, int(w.SpeakerTag))
+		}
+		results = results[:n-1]
+	}
 	transcriptions := make([]Transcription, 0)
-	for _, result := range resp.Results {
-		for _, alt := range result.Alternatives {
-			transcriptions = append(transcriptions, Transcription{
-				Text:       alt.Transcript,
-				confidence: alt.Confidence,
-			})
+	for _, result := range results {
+		// Alternatives are ordered by accuracy, the others would repeat the same passage.
+		if len(result.Alternatives) == 0 {
+			continue
+		}
+		alt := result.Alternatives[0]
+		t := Transcription{
+			Text:       alt.Transcript,
+			Confidence: alt.Confidence,
+		}
+		for _, w := range alt.Words {
+			word := Word{
+				Text:    w.Word,
+				Start:   toDuration(w.GetStartTime()),
+				End:     toDuration(w.GetEndTime()),
+				Speaker: int(w.SpeakerTag),
+			}
+			if len(speakers) > 0 {
+				word.Speaker, speakers = speakers[0], speakers[1:]
+			}
+			t.Words = append(t.Words, word)
 		}
+		transcriptions = append(transcriptions, splitTurns(t)...)
 	}
-	return transcriptions, nil
+	return transcriptions
 }
 
-func (g *gSpeechTranscriber) wait(ctx context.Context, opName string) (*speechpb.LongRunningRecognizeResponse, error) {
-	opClient := longrunningpb.NewOperationsClient(g.client.Connection())
-	var op *longrunningpb.Operation
-	var err error
-	for {
-		op, err = opClient.GetOperation(ctx, &longrunningpb.GetOperationRequest{
-			Name: opName,
-		})
-		if err != nil {
-			return nil, err
+// isDiarization returns whether the last result has the words of all the other results tagged with their speakers.
+func isDiarization(last *speechpb.SpeechRecognitionResult, others []*speechpb.SpeechRecognitionResult) bool {
+	if len(last.Alternatives) == 0 || len(last.Alternatives[0].Words) == 0 || last.Alternatives[0].Words[0].SpeakerTag == 0 {
+		return false
+	}
+	n := 0
+	for _, r := range others {
+		if len(r.Alternatives) > 0 {
+			n += len(r.Alternatives[0].Words)
 		}
-		if op.Done {
-			break
+	}
+	return n == len(last.Alternatives[0].Words)
+}
+
+// splitTurns sets the offsets and speaker of a transcription from its words,
+// splitting it where the speaker changes.
+func splitTurns(t Transcription) []Transcription {
+	if len(t.Words) == 0 {
+		return []Transcription{t}
+	}
+	turns := make([]Transcription, 0)
+	start := 0
+	for i := range t.Words {
+		if i+1 < len(t.Words) && t.Words[i+1].Speaker == t.Words[start].Speaker {
+			continue
+		}
+		turn := Transcription{
+			Text:       t.Text,
+			Start:      t.Words[start].Start,
+			End:        t.Words[i].End,
+			Words:      t.Words[start : i+1],
+			Confidence: t.Confidence,
+			Speaker:    t.Words[start].Speaker,
+		}
+		if start > 0 || i+1 < len(t.Words) {
+			texts := make([]string, 0, len(turn.Words))
+			for _, w := range turn.Words {
+				texts = append(texts, w.Text)
+			}
+			turn.Text = strings.Join(texts, " ")
 		}
-		time.Sleep(500 * time.Millisecond)
+		turns = append(turns, turn)
+		start = i + 1
+	}
+	return turns
+}
+
+func (g *gSpeechTranscriber) wait(ctx context.Context, opName string, started time.Time, audio time.Duration) (*speechpb.LongRunningRecognizeResponse, error) {
+	op, err := poll(ctx, longrunningpb.NewOperationsClient(g.client.Connection()), opName, g.polling, started, audio)
+	if err != nil {
+		return nil, err
 	}
 
 	switch {
@@ -100,24 +245,9 @@ func (g *gSpeechTranscriber) wait(ctx context.Context, opName string) (*speechpb
 	return nil, errors.New("no response")
 }
 
-func (g *gSpeechTranscriber) sendGCS(ctx context.Context, lang, gcsURI string, hints []string) (string, error) {
-	// Take a language and default to French if ends up undefined.
-	var l = language.Make(lang)
-	if l == language.Und {
-		log.Println("Language", lang, "was undefined, defaulting to French")
-		l = language.French
-	}
-	// Not requesting per work offset via "enableWordTimeOffsets": true in the config
-	// as I am not sure how useful it would be...
+func (g *gSpeechTranscriber) sendGCS(ctx context.Context, gcsURI string, opts Options) (string, error) {
 	req := &speechpb.LongRunningRecognizeRequest{
-		Config: &speechpb.RecognitionConfig{
-			Encoding:        speechpb.RecognitionConfig_FLAC,
-			SampleRateHertz: 16000,
-			LanguageCode:    l.String(), // Must be a BCP-47 identifier.
-			SpeechContexts: []*speechpb.SpeechContext{
-				{Phrases: hints},
-			},
-		},
+		Config: recognitionConfig(opts),
 		Audio: &speechpb.RecognitionAudio{
 			AudioSource: &speechpb.RecognitionAudio_Uri{Uri: gcsURI},
 		},
@@ -131,19 +261,81 @@ func (g *gSpeechTranscriber) sendGCS(ctx context.Context, lang, gcsURI string, h
 	return op.Name(), nil
 }
 
-// ConvertToFLAC converts the input audio file into a FLAC audio file as the output filename using the program sox.
+// recognitionConfig returns the configuration of the recognition of FLAC audio with the given options.
+func recognitionConfig(opts Options) *speechpb.RecognitionConfig {
+	l := parseLanguage(opts.Language)
+	config := &speechpb.RecognitionConfig{
+		Encoding:        speechpb.RecognitionConfig_FLAC,
+		SampleRateHertz: 16000,
+		LanguageCode:    l.String(), // Must be a BCP-47 identifier.
+		// Word offsets allow search results to link to the right second of the audio.
+		EnableWordTimeOffsets: true,
+		// Punctuation allows splitting transcripts into sentences before indexing them.
+		EnableAutomaticPunctuation: true,
+		SpeechContexts: []*speechpb.SpeechContext{
+			{Phrases: opts.Hints},
+		},
+	}
+	if opts.Diarization {
+		config.DiarizationConfig = &speechpb.SpeakerDiarizationConfig{
+			EnableSpeakerDiarization: true,
+			MaxSpeakerCount:          int32(opts.MaxSpeakers),
+		}
+	}
+	return config
+}
+
+// ConvertToFLAC converts the input audio or video file of the given format into FLAC audio files using sox or ffmpeg.
 // Returns the output paths.
-func (g *gSpeechTranscriber) ConvertToFLAC(ctx context.Context, soxPath, input string) ([]string, error) {
-	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
-	defer cancel()
-	flacName := input + ".flac"
-	log.Println("Converting", input, "to flac @", flacName)
-	// Convert input to mono FLAC, split output in chunks of 3H as GCP Speech
-	// API supports max 3H chunks.
-	// 10790 = 2.99 hours.
-	err := exec.CommandContext(ctx, soxPath, "-t", "mp3", input, flacName, "channels", "1", "rate", "16k", "trim", "0", "10790", ":", "newfile", ":", "restart").Run()
-	if err != nil {
-		return nil, err
+func (g *gSpeechTranscriber) ConvertToFLAC(ctx context.Context, tools Tools, input string, format Format) ([]string, error) {
+	return convertToFLAC(ctx, tools, input, format)
+}
+
+// parseLanguage takes a language and defaults to French if it ends up undefined.
+func parseLanguage(lang string) language.Tag {
+	l := language.Make(lang)
+	if l == language.Und {
+		log.Println("Language", lang, "was undefined, defaulting to French")
+		l = language.French
+	}
+	return l
+}
+
+// convertToFLAC converts the input file into mono 16kHz FLAC files split in chunks of ChunkDuration.
+// sox is used for the formats it supports, ffmpeg for the others and for unknown formats as it probes its input.
+func convertToFLAC(ctx context.Context, tools Tools, input string, format Format) ([]string, error) {
+	if tools.ConvertTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tools.ConvertTimeout)
+		defer cancel()
+	}
+	chunkSec := strconv.Itoa(int(ChunkDuration.Seconds()))
+	var cmd *exec.Cmd
+	if format.soxSupported() && tools.SoxPath != "" {
+		flacName := input + ".flac"
+		log.Println("Converting", input, "from", format, "to flac @", flacName, "with sox")
+		cmd = exec.CommandContext(ctx, tools.SoxPath, "-t", string(format), input, flacName, "channels", "1", "rate", "16k", "trim", "0", chunkSec, ":", "newfile", ":", "restart")
+	} else {
+		if tools.FFmpegPath == "" {
+			return nil, fmt.Errorf("converting format %q needs ffmpeg", format)
+		}
+		flacPattern := input + "%03d.flac"
+		log.Println("Converting", input, "from", format, "to flac @", flacPattern, "with ffmpeg")
+		cmd = exec.CommandContext(ctx, tools.FFmpegPath, "-nostdin", "-y", "-i", input, "-vn", "-ac", "1", "-ar", "16000", "-c:a", "flac", "-f", "segment", "-segment_time", chunkSec, flacPattern)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, out)
 	}
 	return filepath.Glob(input + "*.flac")
 }
+
+// protoDuration is implemented by protobuf durations.
+type protoDuration interface {
+	GetSeconds() int64
+	GetNanos() int32
+}
+
+// toDuration converts a protobuf duration into a time.Duration.
+func toDuration(d protoDuration) time.Duration {
+	return time.Duration(d.GetSeconds())*time.Second + time.Duration(d.GetNanos())
+}
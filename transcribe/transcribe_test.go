@@ -0,0 +1,91 @@
+package transcribe
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
+)
+
+func TestToTranscriptionsKeepsTopAlternative(t *testing.T) {
+	results := []*speechpb.SpeechRecognitionResult{
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{
+			{Transcript: "bonjour à tous", Confidence: 0.9},
+			{Transcript: "bonjour à tout", Confidence: 0.4},
+		}},
+		{},
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{
+			{Transcript: "commençons", Confidence: 0.5},
+		}},
+	}
+	got := toTranscriptions(results)
+	want := []Transcription{
+		{Text: "bonjour à tous", Confidence: 0.9},
+		{Text: "commençons", Confidence: 0.5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("num transcriptions got=%d, want=%d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Text != w.Text {
+			t.Errorf("[%d] text got=%q, want=%q", i, got[i].Text, w.Text)
+		}
+		if got[i].Confidence != w.Confidence {
+			t.Errorf("[%d] confidence got=%f, want=%f", i, got[i].Confidence, w.Confidence)
+		}
+	}
+}
+
+func TestToTranscriptionsSplitsSpeakerTurns(t *testing.T) {
+	words := func(tags []int32, texts ...string) []*speechpb.WordInfo {
+		ws := make([]*speechpb.WordInfo, 0)
+		for i, text := range texts {
+			w := &speechpb.WordInfo{Word: text}
+			if tags != nil {
+				w.SpeakerTag = tags[i]
+			}
+			ws = append(ws, w)
+		}
+		return ws
+	}
+	results := []*speechpb.SpeechRecognitionResult{
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{
+			{Transcript: "bonjour merci Jean", Words: words(nil, "bonjour", "merci", "Jean")},
+		}},
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{
+			{Transcript: "je commence", Words: words(nil, "je", "commence")},
+		}},
+		// All the words again with their speakers.
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{
+			{Words: words([]int32{1, 2, 2, 2, 2}, "bonjour", "merci", "Jean", "je", "commence")},
+		}},
+	}
+	var got []string
+	for _, tr := range toTranscriptions(results) {
+		got = append(got, fmt.Sprintf("%d:%s", tr.Speaker, tr.Text))
+	}
+	if got, want := strings.Join(got, "|"), "1:bonjour|2:merci Jean|2:je commence"; got != want {
+		t.Errorf("turns got=%s, want=%s", got, want)
+	}
+}
+
+func TestChooseLocalMode(t *testing.T) {
+	var tests = []struct {
+		msg      string
+		duration time.Duration
+		size     int
+		want     localMode
+	}{
+		{"short", 30 * time.Second, 1 << 20, localSync},
+		{"short but too big to be inline", 30 * time.Second, 20 << 20, localStreaming},
+		{"medium", 3 * time.Minute, 3 << 20, localStreaming},
+		{"long", time.Hour, 60 << 20, localNone},
+	}
+	for _, test := range tests {
+		if got, want := chooseLocalMode(test.duration, test.size), test.want; got != want {
+			t.Errorf("[%s] mode got=%d, want=%d", test.msg, got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type whisperTranscriber struct {
+	binPath   string
+	modelPath string
+}
+
+// NewWhisperTranscriber creates a new transcriber that runs a locally installed whisper.cpp binary.
+// No network access is needed, the audio is read directly from the local disk.
+func NewWhisperTranscriber(binPath, modelPath string) Transcriber {
+	return &whisperTranscriber{
+		binPath:   binPath,
+		modelPath: modelPath,
+	}
+}
+
+// CanTranscribeLocal returns true, whisper always reads the audio from the local disk.
+func (w *whisperTranscriber) CanTranscribeLocal(path string) (bool, error) {
+	return true, nil
+}
+
+func (w *whisperTranscriber) TranscribeLocal(ctx context.Context, path string, opts Options) ([]Transcription, error) {
+	return w.Transcribe(ctx, path, opts)
+}
+
+// whisperOutput is the JSON written by whisper.cpp when called with --output-json.
+// Offsets are in milliseconds.
+type whisperOutput struct {
+	Transcription []struct {
+		Offsets struct {
+			From int `json:"from"`
+			To   int `json:"to"`
+		} `json:"offsets"`
+		Text string `json:"text"`
+	} `json:"transcription"`
+}
+
+// Transcribe transcribes a local audio file, whisper does not tell speakers apart so opts.Diarization is ignored.
+func (w *whisperTranscriber) Transcribe(ctx context.Context, path string, opts Options) ([]Transcription, error) {
+	// Paths from a local FileUploader are file:// URIs.
+	path = strings.TrimPrefix(path, "file://")
+	outDir, err := ioutil.TempDir("", "cdf-whisper")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outDir)
+	outPrefix := filepath.Join(outDir, "out")
+	// whisper only understands ISO 639-1 codes, "fr" and not "fr-FR".
+	base, _ := parseLanguage(opts.Language).Base()
+
+	args := []string{
+		"--model", w.modelPath,
+		"--language", base.String(),
+		"--output-json",
+		"--output-file", outPrefix,
+		"--no-prints",
+	}
+	if len(opts.Hints) > 0 {
+		args = append(args, "--prompt", strings.Join(opts.Hints, ", "))
+	}
+	args = append(args, "--file", path)
+	log.Println("Running", w.binPath, args)
+	cmd := exec.CommandContext(ctx, w.binPath, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("running whisper: %v: %s", err, out)
+	}
+
+	f, err := os.Open(outPrefix + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("opening whisper output: %v", err)
+	}
+	defer f.Close()
+	return parseWhisperOutput(f)
+}
+
+// parseWhisperOutput maps the JSON output of whisper.cpp into transcriptions, one per segment.
+func parseWhisperOutput(r io.Reader) ([]Transcription, error) {
+	var out whisperOutput
+	if err := json.NewDecoder(r).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decoding whisper output: %v", err)
+	}
+	transcriptions := make([]Transcription, 0)
+	for _, segment := range out.Transcription {
+		text := strings.TrimSpace(segment.Text)
+		if text == "" {
+			continue
+		}
+		transcriptions = append(transcriptions, Transcription{
+			Text:  text,
+			Start: time.Duration(segment.Offsets.From) * time.Millisecond,
+			End:   time.Duration(segment.Offsets.To) * time.Millisecond,
+		})
+	}
+	return transcriptions, nil
+}
+
+// ConvertToFLAC converts the input audio or video file of the given format into FLAC audio files using sox or ffmpeg.
+// Returns the output paths.
+func (w *whisperTranscriber) ConvertToFLAC(ctx context.Context, tools Tools, input string, format Format) ([]string, error) {
+	return convertToFLAC(ctx, tools, input, format)
+}
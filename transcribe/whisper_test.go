@@ -0,0 +1,42 @@
+package transcribe
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWhisperOutput(t *testing.T) {
+	out := `{
+		"transcription": [
+			{"timestamps": {"from": "00:00:00,000", "to": "00:00:02,000"}, "offsets": {"from": 0, "to": 2000}, "text": " Bonjour à tous."},
+			{"timestamps": {"from": "00:00:02,000", "to": "00:00:03,000"}, "offsets": {"from": 2000, "to": 3000}, "text": " "},
+			{"timestamps": {"from": "00:00:03,000", "to": "00:00:05,000"}, "offsets": {"from": 3000, "to": 5000}, "text": " Commençons."}
+		]
+	}`
+	got, err := parseWhisperOutput(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("parseWhisperOutput: %v", err)
+	}
+	want := []Transcription{
+		{Text: "Bonjour à tous.", Start: 0, End: 2 * time.Second},
+		{Text: "Commençons.", Start: 3 * time.Second, End: 5 * time.Second},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("num transcriptions got=%d, want=%d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Text != w.Text {
+			t.Errorf("[%d] text got=%q, want=%q", i, got[i].Text, w.Text)
+		}
+		if got[i].Start != w.Start || got[i].End != w.End {
+			t.Errorf("[%d] offsets got=[%s, %s], want=[%s, %s]", i, got[i].Start, got[i].End, w.Start, w.End)
+		}
+	}
+}
+
+func TestParseWhisperOutputBadJSON(t *testing.T) {
+	if _, err := parseWhisperOutput(strings.NewReader("garbaaaaage")); err == nil {
+		t.Error("wanted error on bad json but got nil")
+	}
+}
@@ -0,0 +1,67 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+type localFileUploader struct {
+	root string
+}
+
+// NewLocalFileUploader creates a new FileUploader that stores files in the given directory on the local disk.
+func NewLocalFileUploader(root string) (FileUploader, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("creating root dir: %v", err)
+	}
+	return &localFileUploader{
+		root: abs,
+	}, nil
+}
+
+func (u *localFileUploader) Path(base string) string {
+	return "file://" + filepath.ToSlash(u.fullPath(base))
+}
+
+func (u *localFileUploader) UploadFile(ctx context.Context, r io.Reader, name string) error {
+	log.Println("Saving", name, "to", u.root)
+	f, err := os.Create(u.fullPath(name))
+	if err != nil {
+		return fmt.Errorf("create: %v", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("copy: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %v", err)
+	}
+	return nil
+}
+
+func (u *localFileUploader) Delete(ctx context.Context, name string) error {
+	log.Println("Deleting", name, "from", u.root)
+	return os.Remove(u.fullPath(name))
+}
+
+func (u *localFileUploader) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(u.fullPath(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+// fullPath returns the path of the given file name inside the root directory.
+// Only the base name is kept so that files cannot escape the root.
+func (u *localFileUploader) fullPath(name string) string {
+	return filepath.Join(u.root, filepath.Base(name))
+}
@@ -2,13 +2,18 @@ package upload
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"strings"
 
 	"cloud.google.com/go/storage"
 )
 
+// ErrNotExist is returned when downloading a file that does not exist.
+var ErrNotExist = errors.New("file does not exist")
+
 // FileUploader uploads files to a storage service.
 type FileUploader interface {
 	// UploadFile uploads the file read by the given Reader and give it a name as specified.
@@ -17,6 +22,20 @@ type FileUploader interface {
 	Path(base string) string
 	// Delete deletes the given file.
 	Delete(ctx context.Context, name string) error
+	// Download returns the content of the given file, ErrNotExist if there is none.
+	Download(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// NewFileUploader creates the FileUploader of the given storage: "gcs" to use the given Google Cloud Storage bucket
+// or "local:/some/dir" to use a directory on the local disk.
+func NewFileUploader(ctx context.Context, storage, bucket string) (FileUploader, error) {
+	switch {
+	case storage == "gcs":
+		return NewGCSFileUploader(ctx, bucket)
+	case strings.HasPrefix(storage, "local:"):
+		return NewLocalFileUploader(strings.TrimPrefix(storage, "local:"))
+	}
+	return nil, fmt.Errorf("unknown storage %q", storage)
 }
 
 type gcsFileUploader struct {
@@ -58,3 +77,11 @@ func (u *gcsFileUploader) Delete(ctx context.Context, name string) error {
 	o := u.client.Bucket(u.bucket).Object(name)
 	return o.Delete(ctx)
 }
+
+func (u *gcsFileUploader) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	r, err := u.client.Bucket(u.bucket).Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotExist
+	}
+	return r, err
+}
@@ -1,6 +1,13 @@
 package upload
 
-import "testing"
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestPath(t *testing.T) {
 	u := &gcsFileUploader{
@@ -10,3 +17,69 @@ func TestPath(t *testing.T) {
 		t.Errorf("got=%q, want=%q", got, want)
 	}
 }
+
+func TestLocalUploadAndDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cdf-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	u, err := NewLocalFileUploader(dir)
+	if err != nil {
+		t.Fatalf("NewLocalFileUploader: %v", err)
+	}
+	if got, want := u.Path("file.ext"), "file://"+filepath.ToSlash(filepath.Join(dir, "file.ext")); got != want {
+		t.Errorf("Path got=%q, want=%q", got, want)
+	}
+	ctx := context.Background()
+	if err := u.UploadFile(ctx, strings.NewReader("some content"), "file.ext"); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "file.ext"))
+	if err != nil {
+		t.Fatalf("reading uploaded file: %v", err)
+	}
+	if got, want := string(b), "some content"; got != want {
+		t.Errorf("uploaded content got=%q, want=%q", got, want)
+	}
+	r, err := u.Download(ctx, "file.ext")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	b, err = ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("reading downloaded file: %v", err)
+	}
+	if got, want := string(b), "some content"; got != want {
+		t.Errorf("downloaded content got=%q, want=%q", got, want)
+	}
+	if err := u.Delete(ctx, "file.ext"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := u.Download(ctx, "file.ext"); err != ErrNotExist {
+		t.Errorf("Download of deleted file got err=%v, want=%v", err, ErrNotExist)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file.ext")); !os.IsNotExist(err) {
+		t.Errorf("file still exists after Delete, stat err=%v", err)
+	}
+}
+
+func TestNewFileUploader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cdf-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	u, err := NewFileUploader(ctx, "local:"+dir, "")
+	if err != nil {
+		t.Fatalf("NewFileUploader: %v", err)
+	}
+	if got, want := u.Path("file.ext"), "file://"+filepath.ToSlash(filepath.Join(dir, "file.ext")); got != want {
+		t.Errorf("Path got=%q, want=%q", got, want)
+	}
+	if _, err := NewFileUploader(ctx, "s3", ""); err == nil {
+		t.Error("NewFileUploader of unknown storage got no error")
+	}
+}
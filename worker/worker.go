@@ -1,7 +1,9 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,16 +12,27 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/attwad/cdf/artifact"
+	"github.com/attwad/cdf/data"
 	"github.com/attwad/cdf/health"
 	"github.com/attwad/cdf/indexer"
 	"github.com/attwad/cdf/money"
 	"github.com/attwad/cdf/pick"
+	"github.com/attwad/cdf/segment"
+	"github.com/attwad/cdf/subtitle"
 	"github.com/attwad/cdf/transcribe"
 	"github.com/attwad/cdf/upload"
 )
 
+// defaultMaxRetries is how many times a course conversion can fail before it is abandoned and refunded.
+const defaultMaxRetries = 3
+
+// reviewConfidence is the confidence below which transcribed passages are flagged for review.
+const reviewConfidence = 0.6
+
 // Worker does the actual job of checking the balance, scheduling tasks, downloading audio files, transcribing them, etc.
 type Worker struct {
 	uploader    upload.FileUploader
@@ -27,27 +40,42 @@ type Worker struct {
 	broker      money.Broker
 	picker      pick.Picker
 	indexer     indexer.Indexer
-	soxPath     string
+	tools       transcribe.Tools
 	httpClient  *http.Client
 	health      health.Checker
+	maxRetries  int
+	// parallelism is how many courses are processed at the same time.
+	parallelism int
+	// diarized are the lesson types whose speakers are told apart.
+	diarized map[string]bool
 }
 
 // NewGCPWorker creates a new worker that does its work using Google Cloud Platform.
-func NewGCPWorker(u upload.FileUploader, t transcribe.Transcriber, m money.Broker, p pick.Picker, i indexer.Indexer, soxPath string, h health.Checker) *Worker {
+// Up to parallelism scheduled courses are processed concurrently.
+// Courses of the diarized lesson types, such as colloques, are transcribed telling their speakers apart.
+func NewGCPWorker(u upload.FileUploader, t transcribe.Transcriber, m money.Broker, p pick.Picker, i indexer.Indexer, tools transcribe.Tools, h health.Checker, parallelism int, diarized []string) *Worker {
+	d := make(map[string]bool)
+	for _, lessonType := range diarized {
+		d[lessonType] = true
+	}
 	return &Worker{
-		u, t, m, p, i, soxPath,
+		u, t, m, p, i, tools,
 		// Any download of file shouldn't take more than a few minutes really...
 		&http.Client{
 			Timeout: time.Minute * 30,
 		},
 		h,
+		defaultMaxRetries,
+		parallelism,
+		d,
 	}
 }
 
 // Run checks for scheduled tasks and handle all of them if any.
+// Courses are processed concurrently, a failing course does not stop the others.
 func (w *Worker) Run(ctx context.Context) error {
 	if !w.health.IsHealthy() {
-		log.Println("ElasticSearch is not healthy, not running...")
+		log.Println("Search index is not healthy, not running...")
 		return nil
 	}
 	// Handle the scheduled tasks.
@@ -55,90 +83,554 @@ func (w *Worker) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	for key, course := range courses {
+	parallelism := w.parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := make([]string, 0)
+	for key, entry := range courses {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string, entry data.Entry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := w.process(ctx, key, entry); err != nil {
+				if ferr := w.recordFailure(ctx, key, err); ferr != nil {
+					log.Println("[ERROR]: recording failure:", ferr)
+				}
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", entry.AudioLink, err))
+				mu.Unlock()
+			}
+		}(key, entry)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("%d/%d courses failed: %s", len(errs), len(courses), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// recordFailure records that processing the given entry failed.
+// If it failed too many times, the part of the balance that was not spent is given back.
+func (w *Worker) recordFailure(ctx context.Context, key string, cause error) error {
+	e, err := w.picker.RecordFailure(ctx, key, cause.Error(), w.maxRetries)
+	if err != nil {
+		return err
+	}
+	if !e.Failed {
+		log.Printf("%s failed %d/%d times: %v", e.AudioLink, e.Retries, w.maxRetries, cause)
+		return nil
+	}
+	refund := unspentCents(e)
+	log.Printf("%s failed permanently after %d tries, refunding %d usd cents: %v", e.AudioLink, e.Retries, refund, cause)
+	if refund <= 0 {
+		return nil
+	}
+	reason := fmt.Sprintf("%s failed after %d tries: %v", e.AudioLink, e.Retries, cause)
+	return w.broker.Refund(ctx, key, refund, reason)
+}
+
+// unspentCents returns how much of what was debited when scheduling the entry was not spent on speech recognition.
+// Chunks that were submitted for recognition, or sent to it from the local disk, are considered paid for.
+func unspentCents(e data.Entry) int {
+	total := time.Duration(e.DurationSec) * time.Second
+	paidChunks := e.Progress.Chunk
+	if e.Progress.Stage >= data.StageSubmitted || e.Progress.Local {
+		paidChunks++
+	}
+	spent := time.Duration(paidChunks) * transcribe.ChunkDuration
+	if spent > total {
+		spent = total
+	}
+	return money.DurationToUsdCents(total) - money.DurationToUsdCents(spent)
+}
+
+// process runs the conversion pipeline for a single scheduled entry, resuming from the stage saved in its progress.
+// Progress is saved after each step so that a paid transcription is never lost if the worker stops.
+func (w *Worker) process(ctx context.Context, key string, entry data.Entry) error {
+	course := entry.Course
+	p := entry.Progress
+	if p.Stage > data.StageNone {
+		log.Printf("Resuming %s from stage %q, chunk %d", course.AudioLink, p.Stage, p.Chunk)
+	}
+	defer func() {
+		removeFiles(append(p.FLACPaths, p.AudioPath))
+	}()
+	save := func(stage data.Stage) error {
+		p.Stage = stage
+		if err := w.picker.UpdateProgress(ctx, key, p); err != nil {
+			return fmt.Errorf("saving progress: %v", err)
+		}
+		return nil
+	}
+	// ensureFLAC downloads and converts the audio unless the files of a previous run are still on disk.
+	ensureFLAC := func() error {
+		if len(p.FLACPaths) > 0 && filesExist(p.FLACPaths) {
+			return nil
+		}
 		// Download file from the web.
-		log.Println("Downloading", course.AudioLink, "to tmp file")
-		f, tmpCleanup, err := w.downloadToTmpFile(course.AudioLink)
+		log.Println("Downloading", course.MediaLink(), "to tmp file")
+		f, contentType, _, err := w.downloadToTmpFile(course.MediaLink())
 		if err != nil {
 			return err
 		}
-		defer tmpCleanup()
+		format, err := sniffFormat(f, contentType)
+		f.Close()
+		p.AudioPath = f.Name()
+		if err != nil {
+			return err
+		}
+		if err := save(maxStage(p.Stage, data.StageDownloaded)); err != nil {
+			return err
+		}
 		// Convert to FLAC.
-		log.Println("Converting to flac")
-		paths, err := w.transcriber.ConvertToFLAC(ctx, w.soxPath, f.Name())
+		log.Printf("Converting %q to flac", format)
+		paths, err := w.transcriber.ConvertToFLAC(ctx, w.tools, f.Name(), format)
 		if err != nil {
 			return err
 		}
 		log.Println("FLAC files:", paths)
-		fullText := ""
-		for _, flac := range paths {
-			flacReader, err := os.Open(flac)
+		p.FLACPaths = paths
+		if p.Stage < data.StageConvertedToFLAC {
+			if err := w.checkDuration(ctx, key, &course, paths); err != nil {
+				return err
+			}
+		}
+		return save(maxStage(p.Stage, data.StageConvertedToFLAC))
+	}
+
+	if p.Stage < data.StageConvertedToFLAC {
+		if err := ensureFLAC(); err != nil {
+			return err
+		}
+	}
+	for p.Chunk < len(p.FLACPaths) {
+		flacName := filepath.Base(p.FLACPaths[p.Chunk])
+		if p.Stage < data.StageUploaded {
+			if err := ensureFLAC(); err != nil {
+				return err
+			}
+			local, err := w.canTranscribeLocal(p.FLACPaths[p.Chunk])
 			if err != nil {
 				return err
 			}
-			defer flacReader.Close()
-			// Save FLAC to cloud storage.
-			log.Println("Saving flac to could storage")
-			if err := w.uploader.UploadFile(ctx, flacReader, filepath.Base(flac)); err != nil {
+			p.Local = local
+		}
+		if p.Stage < data.StageUploaded && !p.Local {
+			flacReader, err := os.Open(p.FLACPaths[p.Chunk])
+			if err != nil {
 				return err
 			}
-			// Send it to speech recognition.
-			log.Println("Transcribing audio")
-			t, err := w.transcriber.Transcribe(ctx, course.Language, w.uploader.Path(filepath.Base(flac)), course.Hints())
+			// Save FLAC to cloud storage.
+			log.Println("Saving flac to could storage")
+			err = w.uploader.UploadFile(ctx, flacReader, flacName)
+			flacReader.Close()
 			if err != nil {
 				return err
 			}
-			// Save the text output to cloud storage.
-			text := make([]string, 0)
-			for _, b := range t {
-				text = append(text, b.Text)
+			if err := save(data.StageUploaded); err != nil {
+				return err
 			}
-			flacText := strings.Join(text, " ")
-			fullText += flacText + " "
-			textName := filepath.Base(course.AudioLink) + ".txt"
-			log.Println("Saving text to: ", textName)
-			if err := w.uploader.UploadFile(ctx, strings.NewReader(flacText), filepath.Base(textName)); err != nil {
+		}
+		if p.Stage < data.StageIndexed {
+			if err := w.transcribeChunk(ctx, key, course, flacName, &p, save); err != nil {
 				return err
 			}
+		}
+		if !p.Local {
 			// Remove FLAC file from cloud storage.
-			// TODO: defer and panic on error?
 			log.Println("Deleting flac from cloud storage")
-			if err := w.uploader.Delete(ctx, filepath.Base(flac)); err != nil {
-				return err
-			}
-			// Index sentences.
-			log.Println("Indexing text")
-			if err := w.indexer.Index(course, text); err != nil {
+			if err := w.uploader.Delete(ctx, flacName); err != nil {
 				return err
 			}
 		}
-		// Mark the file as converted.
-		log.Println("Marking", course.AudioLink, "as converted")
-		if err := w.picker.MarkConverted(ctx, key, strings.TrimSpace(fullText)); err != nil {
+		// Move on to the next chunk.
+		p.Chunk++
+		p.OperationName = ""
+		p.OperationStarted = time.Time{}
+		p.Local = false
+		if err := save(data.StageConvertedToFLAC); err != nil {
 			return err
 		}
 	}
+	transcript, err := w.fullTranscript(ctx, course, len(p.FLACPaths))
+	if err != nil {
+		return err
+	}
+	if err := w.saveArtifacts(ctx, key, course, transcript, len(p.FLACPaths)); err != nil {
+		return err
+	}
+	// Mark the file as converted.
+	log.Println("Marking", course.AudioLink, "as converted")
+	return w.picker.MarkConverted(ctx, key, transcript, p.LowConfidence)
+}
+
+// fullTranscript returns the texts saved for the chunks of a course, in order.
+func (w *Worker) fullTranscript(ctx context.Context, course data.Course, numChunks int) (string, error) {
+	texts := make([]string, 0, numChunks)
+	for chunk := 0; chunk < numChunks; chunk++ {
+		text, err := w.chunkText(ctx, course, chunk)
+		if err != nil {
+			return "", err
+		}
+		texts = append(texts, text)
+	}
+	return strings.TrimSpace(strings.Join(texts, " ")), nil
+}
+
+// saveArtifacts saves the full transcript of the course, its subtitles and the manifest describing them
+// along with the transcripts of the chunks, which were saved as they were transcribed.
+func (w *Worker) saveArtifacts(ctx context.Context, key string, course data.Course, transcript string, numChunks int) error {
+	textName := artifact.TranscriptName(course.MediaLink())
+	log.Println("Saving full transcript to:", textName)
+	if err := w.uploader.UploadFile(ctx, strings.NewReader(transcript), textName); err != nil {
+		return err
+	}
+	captions, err := w.chunkCaptions(ctx, course, numChunks)
+	if err != nil {
+		return err
+	}
+	subtitles := make([]string, 0)
+	if len(captions) > 0 {
+		log.Println("Saving subtitles of", course.MediaLink())
+		names, err := subtitle.Upload(ctx, w.uploader, course.MediaLink(), captions)
+		if err != nil {
+			return err
+		}
+		subtitles = names
+	}
+	return artifact.NewManifest(key, course, numChunks, subtitles).Upload(ctx, w.uploader)
+}
+
+// saveChunkCaptions saves the captions of the current chunk, they are too many to be kept in the progress.
+func (w *Worker) saveChunkCaptions(ctx context.Context, course data.Course, chunk int, captions []subtitle.Caption) error {
+	b, err := json.Marshal(captions)
+	if err != nil {
+		return err
+	}
+	name := artifact.ChunkCaptionsName(course.MediaLink(), chunk)
+	if err := w.uploader.UploadFile(ctx, bytes.NewReader(b), name); err != nil {
+		return fmt.Errorf("uploading captions of chunk %d: %v", chunk, err)
+	}
 	return nil
 }
 
+// chunkCaptions returns the captions saved for the chunks of a course, in order.
+// Chunks transcribed before captions were saved have none.
+func (w *Worker) chunkCaptions(ctx context.Context, course data.Course, numChunks int) ([]subtitle.Caption, error) {
+	captions := make([]subtitle.Caption, 0)
+	for chunk := 0; chunk < numChunks; chunk++ {
+		name := artifact.ChunkCaptionsName(course.MediaLink(), chunk)
+		r, err := w.uploader.Download(ctx, name)
+		if err == upload.ErrNotExist {
+			log.Println("No captions for chunk", chunk, "of", course.MediaLink())
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("downloading captions of chunk %d: %v", chunk, err)
+		}
+		var cs []subtitle.Caption
+		err = json.NewDecoder(r).Decode(&cs)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding captions of chunk %d: %v", chunk, err)
+		}
+		captions = append(captions, cs...)
+	}
+	return captions, nil
+}
+
+// checkDuration compares the real duration of the converted audio with the scraped one,
+// adjusts what was charged by the difference and corrects the duration of the entry.
+func (w *Worker) checkDuration(ctx context.Context, key string, course *data.Course, flacPaths []string) error {
+	real, err := flacDuration(flacPaths)
+	if err != nil {
+		// Not being able to probe the duration should not prevent the conversion.
+		log.Println("Could not probe the duration of", course.MediaLink(), ":", err)
+		return nil
+	}
+	realSec := int(real.Seconds())
+	if realSec == course.DurationSec {
+		return nil
+	}
+	scraped := time.Duration(course.DurationSec) * time.Second
+	log.Printf("%s lasts %s but was scraped as lasting %s", course.MediaLink(), real, scraped)
+	// The balance is adjusted before the entry so that a restart in between cannot skip the adjustment,
+	// a course is adjusted only once so that it cannot be adjusted twice either.
+	diff := money.DurationToUsdCents(real) - money.DurationToUsdCents(scraped)
+	reason := fmt.Sprintf("%s lasts %s and not %s", course.MediaLink(), real, scraped)
+	if err := w.broker.Adjust(ctx, key, -diff, reason); err != nil {
+		return fmt.Errorf("adjusting the charge by %d usd cents: %v", -diff, err)
+	}
+	course.DurationSec = realSec
+	return w.picker.CorrectDuration(ctx, key, realSec)
+}
+
+// flacDuration returns the total duration of the given FLAC files.
+func flacDuration(paths []string) (time.Duration, error) {
+	var total time.Duration
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		d, err := transcribe.FLACDuration(f)
+		f.Close()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", path, err)
+		}
+		total += d
+	}
+	return total, nil
+}
+
+// transcribeChunk transcribes the current FLAC chunk, saves its text and indexes it.
+// If the text was already saved by a previous run, the transcription of a long running operation is only fetched
+// again to be indexed, the text of a chunk transcribed locally is indexed as it was saved.
+func (w *Worker) transcribeChunk(ctx context.Context, key string, course data.Course, flacName string, p *data.Progress, save func(data.Stage) error) error {
+	var t []transcribe.Transcription
+	var err error
+	if p.Local && p.Stage >= data.StageTranscribed {
+		// Transcribing it again would pay for the same audio twice.
+		log.Println("Reading the saved transcript of chunk", p.Chunk)
+		t, err = w.savedTranscription(ctx, course, p.Chunk)
+	} else {
+		// Send it to speech recognition.
+		log.Println("Transcribing audio")
+		t, err = w.transcribe(ctx, course, flacName, p, save)
+	}
+	if err != nil {
+		return err
+	}
+	text := make([]string, 0)
+	for _, b := range t {
+		text = append(text, b.Text)
+	}
+	sentences := make([]indexer.Sentence, 0)
+	// Offsets are relative to the start of the current FLAC chunk.
+	offset := time.Duration(p.Chunk) * transcribe.ChunkDuration
+	split := segment.Split(course.Language, t)
+	for i, s := range segment.Windows(split, segment.WindowSize, segment.WindowOverlap) {
+		sentences = append(sentences, indexer.Sentence{Serial: p.Sentences + i, Text: s.Text, Start: offset + s.Start, End: offset + s.End, Confidence: s.Confidence, Speaker: s.Speaker})
+	}
+	if p.Stage < data.StageTranscribed {
+		// Save the text output of the chunk to cloud storage.
+		flacText := strings.Join(text, " ")
+		textName := artifact.ChunkTranscriptName(course.MediaLink(), p.Chunk)
+		log.Println("Saving text to: ", textName)
+		if err := w.uploader.UploadFile(ctx, strings.NewReader(flacText), textName); err != nil {
+			return err
+		}
+		if err := w.saveChunkCaptions(ctx, course, p.Chunk, subtitle.Captions(split, offset)); err != nil {
+			return err
+		}
+		p.LowConfidence = append(p.LowConfidence, lowConfidence(t, offset)...)
+		if err := save(data.StageTranscribed); err != nil {
+			return err
+		}
+	}
+	if p.Chunk == 0 {
+		// Remove what a previous failed conversion may have indexed.
+		if err := w.indexer.Delete(course); err != nil {
+			return fmt.Errorf("deleting previously indexed sentences: %v", err)
+		}
+	}
+	// Index sentences, indexing them again after a crash replaces them.
+	log.Println("Indexing text")
+	if err := w.indexer.Index(key, course, sentences); err != nil {
+		return err
+	}
+	p.Sentences += len(sentences)
+	return save(data.StageIndexed)
+}
+
+// savedTranscription returns the text saved for a chunk as a single transcription spanning the chunk,
+// the times of its words are estimated.
+func (w *Worker) savedTranscription(ctx context.Context, course data.Course, chunk int) ([]transcribe.Transcription, error) {
+	text, err := w.chunkText(ctx, course, chunk)
+	if err != nil {
+		return nil, err
+	}
+	return []transcribe.Transcription{{Text: text, End: chunkDuration(course, chunk)}}, nil
+}
+
+// chunkText returns the text saved for a chunk when it was transcribed.
+func (w *Worker) chunkText(ctx context.Context, course data.Course, chunk int) (string, error) {
+	name := artifact.ChunkTranscriptName(course.MediaLink(), chunk)
+	r, err := w.uploader.Download(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s: %v", name, err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", name, err)
+	}
+	return string(b), nil
+}
+
+// lowConfidence returns the transcriptions whose confidence is known and below reviewConfidence.
+func lowConfidence(ts []transcribe.Transcription, offset time.Duration) []data.Span {
+	spans := make([]data.Span, 0)
+	for _, t := range ts {
+		if t.Confidence <= 0 || t.Confidence >= reviewConfidence {
+			continue
+		}
+		spans = append(spans, data.Span{
+			StartSec:   int((offset + t.Start).Seconds()),
+			EndSec:     int((offset + t.End).Seconds()),
+			Text:       t.Text,
+			Confidence: t.Confidence,
+		})
+	}
+	return spans
+}
+
+// transcribe transcribes the current FLAC chunk, from the local disk if it was not uploaded.
+// If the transcriber supports it, the operation name is saved before waiting for it so that it can be resumed.
+func (w *Worker) transcribe(ctx context.Context, course data.Course, flacName string, p *data.Progress, save func(data.Stage) error) ([]transcribe.Transcription, error) {
+	opts := w.options(course)
+	if p.Local {
+		lt, ok := w.transcriber.(transcribe.LocalTranscriber)
+		if !ok {
+			return nil, fmt.Errorf("the transcriber cannot transcribe %s from the local disk", flacName)
+		}
+		// Saved before the request so that the chunk counts as paid for if the conversion is abandoned.
+		if err := save(p.Stage); err != nil {
+			return nil, err
+		}
+		return lt.TranscribeLocal(ctx, p.FLACPaths[p.Chunk], opts)
+	}
+	rt, ok := w.transcriber.(transcribe.ResumableTranscriber)
+	if !ok {
+		return w.transcriber.Transcribe(ctx, w.uploader.Path(flacName), opts)
+	}
+	if p.OperationName == "" {
+		started := time.Now()
+		opName, err := rt.Start(ctx, w.uploader.Path(flacName), opts)
+		if err != nil {
+			return nil, err
+		}
+		p.OperationName = opName
+		p.OperationStarted = started
+		if err := save(data.StageSubmitted); err != nil {
+			return nil, err
+		}
+	}
+	if p.OperationStarted.IsZero() {
+		// Operations submitted before their start was saved are given a deadline from now, once.
+		p.OperationStarted = time.Now()
+		if err := save(p.Stage); err != nil {
+			return nil, err
+		}
+	}
+	log.Println("Waiting for operation", p.OperationName)
+	t, err := rt.Resume(ctx, p.OperationName, p.OperationStarted, chunkDuration(course, p.Chunk))
+	if _, ok := err.(*transcribe.DeadlineError); ok {
+		// The chunk is still uploaded, the next run submits it again instead of waiting for the same operation.
+		p.OperationName = ""
+		p.OperationStarted = time.Time{}
+		if serr := save(data.StageUploaded); serr != nil {
+			return nil, fmt.Errorf("%v, then %v", err, serr)
+		}
+	}
+	return t, err
+}
+
+// canTranscribeLocal returns whether the local FLAC file can be transcribed without being uploaded.
+func (w *Worker) canTranscribeLocal(path string) (bool, error) {
+	lt, ok := w.transcriber.(transcribe.LocalTranscriber)
+	if !ok {
+		return false, nil
+	}
+	return lt.CanTranscribeLocal(path)
+}
+
+// options returns the transcription options of a course.
+func (w *Worker) options(course data.Course) transcribe.Options {
+	return transcribe.Options{
+		Language:    course.Language,
+		Hints:       course.Hints(),
+		Diarization: w.diarized[course.LessonType],
+	}
+}
+
+// chunkDuration returns the duration of a FLAC chunk from the duration of the course, which is corrected once the
+// audio is converted, so that it is known even if the chunk is no longer on the local disk. Zero if unknown.
+func chunkDuration(course data.Course, chunk int) time.Duration {
+	d := time.Duration(course.DurationSec)*time.Second - time.Duration(chunk)*transcribe.ChunkDuration
+	switch {
+	case d <= 0:
+		return 0
+	case d > transcribe.ChunkDuration:
+		return transcribe.ChunkDuration
+	}
+	return d
+}
+
+func maxStage(a, b data.Stage) data.Stage {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// filesExist returns whether all the given local files exist.
+func filesExist(paths []string) bool {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// removeFiles removes the given local files, ignoring errors.
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		if path != "" {
+			os.Remove(path)
+		}
+	}
+}
+
 // downloadToTmpFile downloads the url target into a temporary file that should be cleaned up by calling the cleanup function returned by this method.
-func (w *Worker) downloadToTmpFile(url string) (*os.File, func(), error) {
+// Also returns the Content-Type of the file as sent by the server.
+func (w *Worker) downloadToTmpFile(url string) (*os.File, string, func(), error) {
 	tmpFile, err := ioutil.TempFile("", "cdf-dl")
 	if err != nil {
-		return nil, func() {}, err
+		return nil, "", func() {}, err
 	}
 	cleanup := func() { os.Remove(tmpFile.Name()) }
 	resp, err := w.httpClient.Get(url)
 	if err != nil {
 		cleanup()
-		return nil, func() {}, err
+		return nil, "", func() {}, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		cleanup()
+		return nil, "", func() {}, fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
 	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
 		cleanup()
-		return nil, func() {}, err
+		return nil, "", func() {}, err
 	}
-	return tmpFile, cleanup, nil
+	return tmpFile, resp.Header.Get("Content-Type"), cleanup, nil
+}
+
+// sniffFormat detects the format of the downloaded file from its first bytes and Content-Type.
+func sniffFormat(f *os.File, contentType string) (transcribe.Format, error) {
+	header := make([]byte, 512)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return transcribe.FormatUnknown, fmt.Errorf("reading file header: %v", err)
+	}
+	return transcribe.DetectFormat(contentType, header[:n]), nil
 }
 
 // MaybeSchedule checks the current balance and schedule new audio tracks to be
@@ -158,7 +650,7 @@ func (w *Worker) MaybeSchedule(ctx context.Context) (bool, error) {
 	}
 	equivDuration := money.UsdCentsToDuration(balance)
 	log.Println("Current balance can schedule up to", equivDuration)
-	length, err := w.picker.ScheduleRandom(ctx, equivDuration)
+	key, length, err := w.picker.ScheduleRandom(ctx, equivDuration)
 	if err != nil {
 		return false, fmt.Errorf("sheduling random lesson: %v", err)
 	}
@@ -167,8 +659,15 @@ func (w *Worker) MaybeSchedule(ctx context.Context) (bool, error) {
 		return false, nil
 	}
 	log.Println("New task scheduled:", length)
-	equivBalance := money.DurationToUsdCents(length)
-	if err := w.broker.ChangeBalance(ctx, -equivBalance); err != nil {
+	if err := w.broker.Charge(ctx, key, length); err != nil {
+		// Another worker may have spent the balance in the meantime.
+		if uerr := w.picker.Unschedule(ctx, key); uerr != nil {
+			return false, fmt.Errorf("unscheduling after failed charge (%v): %v", err, uerr)
+		}
+		if err == money.ErrInsufficientFunds {
+			log.Println("Balance too low after all, unscheduled")
+			return false, nil
+		}
 		return false, err
 	}
 	log.Println("Decreased balance")
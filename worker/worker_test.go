@@ -3,15 +3,22 @@ package worker
 import (
 	"context"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/attwad/cdf/data"
+	"github.com/attwad/cdf/indexer"
+	"github.com/attwad/cdf/memstore"
+	"github.com/attwad/cdf/money"
 	"github.com/attwad/cdf/transcribe"
+	"github.com/attwad/cdf/upload"
 )
 
 type fakeHealthChecker struct {
@@ -23,120 +30,113 @@ func (fhc *fakeHealthChecker) IsHealthy() bool {
 	return fhc.healthy
 }
 
-type fakePicker struct {
-	scheduledCourses map[string]data.Course
-	convertedKey     string
-	scheduledLength  int
-	fullText         string
+// failingBroker fails to read the balance.
+type failingBroker struct {
+	money.Broker
 }
 
-func (p *fakePicker) ScheduleRandom(context.Context, time.Duration) (time.Duration, error) {
-	return time.Duration(p.scheduledLength) * time.Second, nil
+func (b failingBroker) GetBalance(ctx context.Context) (int, error) {
+	return 0, fmt.Errorf("not connected")
 }
 
-func (p *fakePicker) GetScheduled(context.Context) (map[string]data.Course, error) {
-	return p.scheduledCourses, nil
+// spendingBroker spends the whole balance on another course right after reading it, as a concurrent worker would.
+type spendingBroker struct {
+	money.Broker
 }
 
-func (p *fakePicker) MarkConverted(_ context.Context, key, fullText string) error {
-	p.convertedKey = key
-	p.fullText = fullText
-	return nil
+func (b spendingBroker) GetBalance(ctx context.Context) (int, error) {
+	balance, err := b.Broker.GetBalance(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return balance, b.Broker.Adjust(ctx, "other", -balance, "concurrent worker")
 }
 
 type fakeTranscriber struct {
 	transcription []transcribe.Transcription
+	numConverted  int
+	opts          []transcribe.Options
 }
 
-func (t *fakeTranscriber) Transcribe(ctx context.Context, lang, path string, hints []string) ([]transcribe.Transcription, error) {
+func (t *fakeTranscriber) Transcribe(ctx context.Context, path string, opts transcribe.Options) ([]transcribe.Transcription, error) {
+	t.opts = append(t.opts, opts)
 	return t.transcription, nil
 }
 
-func (t *fakeTranscriber) ConvertToFLAC(ctx context.Context, soxPath, input string) ([]string, error) {
+func (t *fakeTranscriber) ConvertToFLAC(ctx context.Context, tools transcribe.Tools, input string, format transcribe.Format) ([]string, error) {
+	t.numConverted++
 	return []string{input}, nil
 }
 
-type fakeBroker struct {
-	balance         int
-	getBalanceError error
-}
-
-func (b *fakeBroker) GetBalance(ctx context.Context) (int, error) {
-	return b.balance, b.getBalanceError
+type fakeResumableTranscriber struct {
+	fakeTranscriber
+	startedPaths   []string
+	resumedOpNames []string
+	resumedStarts  []time.Time
+	resumeErr      error
 }
 
-func (b *fakeBroker) ChangeBalance(ctx context.Context, delta int) error {
-	b.balance -= delta
-	return nil
+func (t *fakeResumableTranscriber) Start(ctx context.Context, path string, opts transcribe.Options) (string, error) {
+	t.startedPaths = append(t.startedPaths, path)
+	return "op-" + path, nil
 }
 
-type fakeUploader struct {
-	uploadedFiles []string
-	deletedFiles  []string
-}
-
-func (f *fakeUploader) UploadFile(ctx context.Context, r io.Reader, name string) error {
-	f.uploadedFiles = append(f.uploadedFiles, name)
-	return nil
-}
-
-func (f *fakeUploader) Path(base string) string {
-	return base
+func (t *fakeResumableTranscriber) Resume(ctx context.Context, opName string, started time.Time, audio time.Duration) ([]transcribe.Transcription, error) {
+	t.resumedOpNames = append(t.resumedOpNames, opName)
+	t.resumedStarts = append(t.resumedStarts, started)
+	if t.resumeErr != nil {
+		return nil, t.resumeErr
+	}
+	return t.transcription, nil
 }
 
-func (f *fakeUploader) Delete(ctx context.Context, name string) error {
-	f.deletedFiles = append(f.deletedFiles, name)
-	return nil
+type fakeLocalTranscriber struct {
+	fakeResumableTranscriber
+	canLocal   bool
+	localPaths []string
 }
 
-type fakeIndexer struct {
-	indexedText string
+func (t *fakeLocalTranscriber) CanTranscribeLocal(path string) (bool, error) {
+	return t.canLocal, nil
 }
 
-func (f *fakeIndexer) Index(course data.Course, text []string) error {
-	f.indexedText += strings.Join(text, "")
-	return nil
+func (t *fakeLocalTranscriber) TranscribeLocal(ctx context.Context, path string, opts transcribe.Options) ([]transcribe.Transcription, error) {
+	t.localPaths = append(t.localPaths, path)
+	return t.transcription, nil
 }
 
 func TestMaybeSchedule(t *testing.T) {
 	var tests = []struct {
 		msg           string
-		w             Worker
+		deposit       int
+		durationSec   int
+		broker        func(money.Broker) money.Broker
 		taskScheduled bool
 		wantError     bool
 	}{
 		{
-			msg: "balance ok",
-			w: Worker{
-				broker: &fakeBroker{balance: 500},
-				picker: &fakePicker{scheduledLength: 10},
-			},
+			msg:           "balance ok",
+			deposit:       500,
+			durationSec:   10,
 			taskScheduled: true,
 			wantError:     false,
 		}, {
-			msg: "not enough balance",
-			w: Worker{
-				broker: &fakeBroker{balance: 10},
-				picker: &fakePicker{},
-			},
+			msg:           "not enough balance",
+			deposit:       10,
+			durationSec:   3600,
 			taskScheduled: false,
 			wantError:     false,
 		}, {
-			msg: "nothing to schedule",
-			w: Worker{
-				broker: &fakeBroker{balance: 10},
-				picker: &fakePicker{},
-			},
+			msg:           "nothing to schedule",
+			deposit:       10,
 			taskScheduled: false,
 			wantError:     false,
 		}, {
-			msg: "error checking balance",
-			w: Worker{
-				broker: &fakeBroker{
-					balance:         500,
-					getBalanceError: fmt.Errorf("not connected"),
-				},
-				picker: &fakePicker{},
+			msg:         "error checking balance",
+			deposit:     500,
+			durationSec: 10,
+			broker: func(b money.Broker) money.Broker {
+				return failingBroker{b}
 			},
 			taskScheduled: false,
 			wantError:     true,
@@ -144,7 +144,22 @@ func TestMaybeSchedule(t *testing.T) {
 	}
 	ctx := context.Background()
 	for _, test := range tests {
-		taskScheduled, err := test.w.MaybeSchedule(ctx)
+		s := memstore.New()
+		if test.durationSec > 0 {
+			s.Put(data.Entry{Course: data.Course{DurationSec: test.durationSec}})
+		}
+		b := memstore.NewBroker(s)
+		if err := b.Deposit(ctx, test.deposit, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if test.broker != nil {
+			b = test.broker(b)
+		}
+		w := Worker{
+			broker: b,
+			picker: memstore.NewPicker(s),
+		}
+		taskScheduled, err := w.MaybeSchedule(ctx)
 		if got, want := taskScheduled, test.taskScheduled; got != want {
 			t.Errorf("[%s] task scheduled, got=%t, want=%t", test.msg, got, want)
 		}
@@ -154,8 +169,34 @@ func TestMaybeSchedule(t *testing.T) {
 	}
 }
 
+func TestMaybeScheduleUnschedulesWhenChargeFails(t *testing.T) {
+	s := memstore.New()
+	key := s.Put(data.Entry{Course: data.Course{DurationSec: 60}})
+	b := memstore.NewBroker(s)
+	ctx := context.Background()
+	if err := b.Deposit(ctx, 100, "test"); err != nil {
+		t.Fatal(err)
+	}
+	w := Worker{
+		broker: spendingBroker{b},
+		picker: memstore.NewPicker(s),
+	}
+	taskScheduled, err := w.MaybeSchedule(ctx)
+	if err != nil {
+		t.Fatalf("MaybeSchedule: %v", err)
+	}
+	if taskScheduled {
+		t.Error("task scheduled, got=true, want=false")
+	}
+	if e, _ := s.Get(key); e.Scheduled {
+		t.Error("Entry still scheduled after the failed charge")
+	}
+}
+
 func TestDownloadToTmpFile(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/ogg")
+	}))
 	defer ts.Close()
 
 	w := Worker{
@@ -163,57 +204,592 @@ func TestDownloadToTmpFile(t *testing.T) {
 			Timeout: time.Second * 5,
 		},
 	}
-	_, cleanup, err := w.downloadToTmpFile(ts.URL)
+	_, contentType, cleanup, err := w.downloadToTmpFile(ts.URL)
 	if err != nil {
 		t.Fatalf("downloadToTmpFile: %v", err)
 	}
 	defer cleanup()
+	if got, want := contentType, "audio/ogg"; got != want {
+		t.Errorf("Content type, got=%q, want=%q", got, want)
+	}
+}
+
+func TestDownloadToTmpFileNotFound(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	w := Worker{
+		httpClient: &http.Client{
+			Timeout: time.Second * 5,
+		},
+	}
+	if _, _, _, err := w.downloadToTmpFile(ts.URL); err == nil {
+		t.Error("downloadToTmpFile: wanted error on 404, got nil")
+	}
 }
 
 func TestRun(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	defer ts.Close()
-	fp := &fakePicker{
-		scheduledCourses: map[string]data.Course{"k1": {AudioLink: ts.URL}},
+	s := memstore.New()
+	key := s.Put(data.Entry{
+		Course:    data.Course{Source: "s1", AudioLink: ts.URL, LessonType: "Colloque", Language: "en"},
+		Scheduled: true,
+	})
+	u := &memstore.Uploader{}
+	fi := &memstore.Indexer{}
+	// A sentence left over by a previous attempt.
+	if err := fi.Index(key, data.Course{Source: "s1"}, []indexer.Sentence{{Text: "stale", Serial: 3}}); err != nil {
+		t.Fatal(err)
 	}
-	fu := &fakeUploader{uploadedFiles: make([]string, 0)}
-	fi := &fakeIndexer{}
 	transcript := []transcribe.Transcription{
-		{Text: "line 1"},
-		{Text: "line 2"},
+		{Text: "line 1", Confidence: 0.9},
+		{Text: "line 2", Start: 2 * time.Second, End: 3 * time.Second, Confidence: 0.3},
 	}
+	ft := &fakeTranscriber{transcription: transcript}
 	w := Worker{
-		picker:      fp,
-		transcriber: &fakeTranscriber{transcription: transcript},
-		uploader:    fu,
+		picker:      memstore.NewPicker(s),
+		transcriber: ft,
+		uploader:    u,
 		indexer:     fi,
 		httpClient: &http.Client{
 			Timeout: time.Second * 5,
 		},
-		health: &fakeHealthChecker{healthy: true},
+		health:   &fakeHealthChecker{healthy: true},
+		diarized: map[string]bool{"Colloque": true},
 	}
 	ctx := context.Background()
 	if err := w.Run(ctx); err != nil {
 		t.Errorf("Run: %v", err)
 	}
 	// Check that we marked the file as completed.
-	if got, want := fp.convertedKey, "k1"; got != want {
-		t.Errorf("Converted key, got=%q, want=%q", got, want)
+	e, _ := s.Get(key)
+	if !e.Converted {
+		t.Error("Entry was not marked as converted")
+	}
+	// Check that the speakers of the colloque were told apart.
+	if got, want := len(ft.opts), 1; got != want {
+		t.Fatalf("Num transcriptions, got=%d, want=%d", got, want)
+	}
+	if got, want := ft.opts[0].Language+" "+fmt.Sprint(ft.opts[0].Diarization), "en true"; got != want {
+		t.Errorf("Transcription language and diarization, got=%q, want=%q", got, want)
 	}
 	// Check that we saved the transcript.
-	if got, want := fp.fullText, "line 1 line 2"; got != want {
+	if got, want := e.Transcript, "line 1 line 2"; got != want {
 		t.Errorf("Saved transcript, got=%q, want=%q", got, want)
 	}
-	// Check that we saved the flac and text file with the transcript.
-	if got, want := len(fu.uploadedFiles), 2; got != want {
-		t.Errorf("Num saved files, got=%d, want=%d", got, want)
+	// Check that the poorly recognized passage is flagged for review.
+	if got, want := fmt.Sprint(e.LowConfidence), "[{2 3 line 2 0.3}]"; got != want {
+		t.Errorf("Low confidence spans, got=%s, want=%s", got, want)
+	}
+	// Check that we saved the flac, the text of the chunk, the full transcript, its subtitles and the manifest.
+	uploaded := u.Uploaded()
+	if got, want := len(uploaded), 7; got != want {
+		t.Fatalf("Num saved files, got=%d, want=%d", got, want)
+	}
+	base := filepath.Base(ts.URL)
+	if got, want := fmt.Sprint(uploaded[1:]), fmt.Sprintf("[%[1]s.chunk-0.txt %[1]s.chunk-0.captions.json %[1]s.txt %[1]s.vtt %[1]s.srt %[1]s.json]", base); got != want {
+		t.Errorf("Saved files, got=%s, want=%s", got, want)
 	}
 	// Check that we deleted the flac file.
-	if got, want := len(fu.deletedFiles), 1; got != want {
+	if got, want := len(u.Deleted()), 1; got != want {
 		t.Errorf("Num deleted files, got=%d, want=%d", got, want)
 	}
-	// Check that we indexed the transcript.
-	if got, want := fi.indexedText, "line 1line 2"; got != want {
-		t.Errorf("Num indexed text, got=%q, want=%q", got, want)
+	// Check that we indexed the transcript, without punctuation it is a single sentence,
+	// and that the sentences of previous attempts were deleted first.
+	sentences := fi.Sentences("s1")
+	if got, want := len(sentences), 1; got != want {
+		t.Fatalf("Num indexed sentences, got=%d, want=%d", got, want)
+	}
+	if got, want := sentences[0].Text, "line 1 line 2"; got != want {
+		t.Errorf("Indexed text, got=%q, want=%q", got, want)
+	}
+	// Check that we indexed the sentence offset and serial.
+	if got, want := sentences[0].Start, time.Duration(0); got != want {
+		t.Errorf("Indexed start offset, got=%s, want=%s", got, want)
+	}
+	if got, want := sentences[0].Serial, 0; got != want {
+		t.Errorf("Indexed serial, got=%d, want=%d", got, want)
+	}
+}
+
+func TestRunTranscribesShortAudioLocally(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	s := memstore.New()
+	key := s.Put(data.Entry{Course: data.Course{AudioLink: ts.URL}, Scheduled: true})
+	u := &memstore.Uploader{}
+	ft := &fakeLocalTranscriber{
+		fakeResumableTranscriber: fakeResumableTranscriber{
+			fakeTranscriber: fakeTranscriber{transcription: []transcribe.Transcription{{Text: "short"}}},
+		},
+		canLocal: true,
+	}
+	w := Worker{
+		picker:      memstore.NewPicker(s),
+		transcriber: ft,
+		uploader:    u,
+		indexer:     &memstore.Indexer{},
+		httpClient:  &http.Client{Timeout: time.Second * 5},
+		health:      &fakeHealthChecker{healthy: true},
+	}
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got, want := len(ft.localPaths), 1; got != want {
+		t.Errorf("Num local transcriptions, got=%d, want=%d", got, want)
+	}
+	if got, want := len(ft.startedPaths), 0; got != want {
+		t.Errorf("Num started operations, got=%d, want=%d", got, want)
+	}
+	// Check that the flac was neither uploaded nor deleted.
+	base := filepath.Base(ts.URL)
+	if got, want := fmt.Sprint(u.Uploaded()), fmt.Sprintf("[%[1]s.chunk-0.txt %[1]s.chunk-0.captions.json %[1]s.txt %[1]s.vtt %[1]s.srt %[1]s.json]", base); got != want {
+		t.Errorf("Saved files, got=%s, want=%s", got, want)
+	}
+	if got, want := len(u.Deleted()), 0; got != want {
+		t.Errorf("Num deleted files, got=%d, want=%d", got, want)
+	}
+	if e, _ := s.Get(key); e.Transcript != "short" {
+		t.Errorf("Saved transcript, got=%q, want=%q", e.Transcript, "short")
+	}
+}
+
+func TestRunIndexesSavedTranscriptOfLocalChunk(t *testing.T) {
+	s := memstore.New()
+	key := s.Put(data.Entry{
+		Course:    data.Course{Source: "s1", AudioLink: "http://unused", DurationSec: 30},
+		Scheduled: true,
+		Progress: data.Progress{
+			Stage:     data.StageTranscribed,
+			FLACPaths: []string{"/gone/a.flac"},
+			Local:     true,
+		},
+	})
+	u := &memstore.Uploader{}
+	ctx := context.Background()
+	if err := u.UploadFile(ctx, strings.NewReader("saved text"), "unused.chunk-0.txt"); err != nil {
+		t.Fatal(err)
+	}
+	fi := &memstore.Indexer{}
+	ft := &fakeLocalTranscriber{canLocal: true}
+	w := Worker{
+		picker:      memstore.NewPicker(s),
+		transcriber: ft,
+		uploader:    u,
+		indexer:     fi,
+		health:      &fakeHealthChecker{healthy: true},
+	}
+	if err := w.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// Check that the chunk was neither converted nor transcribed again.
+	if got, want := ft.numConverted+len(ft.localPaths), 0; got != want {
+		t.Errorf("Num conversions and transcriptions, got=%d, want=%d", got, want)
+	}
+	sentences := fi.Sentences("s1")
+	if got, want := len(sentences), 1; got != want {
+		t.Fatalf("Num indexed sentences, got=%d, want=%d", got, want)
+	}
+	if got, want := sentences[0].Text, "saved text"; got != want {
+		t.Errorf("Indexed text, got=%q, want=%q", got, want)
+	}
+	if got, want := sentences[0].Start, time.Duration(0); got != want {
+		t.Errorf("Indexed start offset, got=%s, want=%s", got, want)
+	}
+	if e, _ := s.Get(key); e.Transcript != "saved text" {
+		t.Errorf("Saved transcript, got=%q, want=%q", e.Transcript, "saved text")
+	}
+}
+
+func TestRunResumesSubmittedOperation(t *testing.T) {
+	s := memstore.New()
+	key := s.Put(data.Entry{
+		Course:    data.Course{Source: "s1", AudioLink: "http://unused"},
+		Scheduled: true,
+		Progress: data.Progress{
+			Stage:            data.StageSubmitted,
+			FLACPaths:        []string{"/gone/a.flac", "/gone/b.flac"},
+			Chunk:            1,
+			OperationName:    "op-b",
+			OperationStarted: time.Date(2017, 6, 23, 16, 15, 0, 0, time.UTC),
+			Sentences:        4,
+			LowConfidence:    []data.Span{{StartSec: 1, EndSec: 2, Text: "chunk a", Confidence: 0.5}},
+		},
+	})
+	u := &memstore.Uploader{}
+	ctx := context.Background()
+	// The text and captions of the first chunk were saved before the restart.
+	if err := u.UploadFile(ctx, strings.NewReader("chunk a"), "unused.chunk-0.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.UploadFile(ctx, strings.NewReader(`[{"Start":0,"End":1000000000,"Text":"chunk a"}]`), "unused.chunk-0.captions.json"); err != nil {
+		t.Fatal(err)
+	}
+	// So were its sentences indexed.
+	fi := &memstore.Indexer{}
+	var previous []indexer.Sentence
+	for i := 0; i < 4; i++ {
+		previous = append(previous, indexer.Sentence{Text: "chunk a", Serial: i})
+	}
+	if err := fi.Index(key, data.Course{Source: "s1"}, previous); err != nil {
+		t.Fatal(err)
+	}
+	ft := &fakeResumableTranscriber{
+		fakeTranscriber: fakeTranscriber{
+			transcription: []transcribe.Transcription{{Text: "chunk b", Start: time.Second, End: 2 * time.Second, Confidence: 0.4}},
+		},
+	}
+	w := Worker{
+		picker:      memstore.NewPicker(s),
+		transcriber: ft,
+		uploader:    u,
+		indexer:     fi,
+		health:      &fakeHealthChecker{healthy: true},
+	}
+	if err := w.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// Check that we neither downloaded nor started a new operation.
+	if got, want := ft.numConverted, 0; got != want {
+		t.Errorf("Num converted, got=%d, want=%d", got, want)
+	}
+	if got, want := len(ft.startedPaths), 0; got != want {
+		t.Errorf("Num started operations, got=%d, want=%d", got, want)
+	}
+	if got, want := fmt.Sprint(ft.resumedOpNames), "[op-b]"; got != want {
+		t.Errorf("Resumed operations, got=%s, want=%s", got, want)
+	}
+	// Check that the deadline of the operation still runs from when it was started.
+	if got, want := ft.resumedStarts, []time.Time{time.Date(2017, 6, 23, 16, 15, 0, 0, time.UTC)}; len(got) != 1 || !got[0].Equal(want[0]) {
+		t.Errorf("Resumed operation starts, got=%v, want=%v", got, want)
+	}
+	e, _ := s.Get(key)
+	if !e.Converted {
+		t.Error("Entry was not marked as converted")
+	}
+	// Check that the transcript contains the previous chunk.
+	if got, want := e.Transcript, "chunk a chunk b"; got != want {
+		t.Errorf("Saved transcript, got=%q, want=%q", got, want)
+	}
+	// Check that the spans to review of the previous chunk are kept.
+	if got, want := len(e.LowConfidence), 2; got != want {
+		t.Fatalf("Num low confidence spans, got=%d, want=%d", got, want)
+	}
+	if got, want := e.LowConfidence[1].StartSec, int((transcribe.ChunkDuration + time.Second).Seconds()); got != want {
+		t.Errorf("Low confidence span start, got=%d, want=%d", got, want)
+	}
+	// Check that serials follow the sentences of the previous chunk, which are kept.
+	sentences := fi.Sentences("s1")
+	if got, want := len(sentences), 5; got != want {
+		t.Fatalf("Num indexed sentences, got=%d, want=%d", got, want)
+	}
+	if got, want := sentences[4].Text, "chunk b"; got != want {
+		t.Errorf("Indexed text, got=%q, want=%q", got, want)
+	}
+	if got, want := sentences[4].Start, transcribe.ChunkDuration+time.Second; got != want {
+		t.Errorf("Indexed start offset, got=%s, want=%s", got, want)
+	}
+	// Check that the text of the second chunk does not replace the one of the first chunk.
+	if got, want := fmt.Sprint(u.Uploaded()[2:]), "[unused.chunk-1.txt unused.chunk-1.captions.json unused.txt unused.vtt unused.srt unused.json]"; got != want {
+		t.Errorf("Saved files, got=%s, want=%s", got, want)
+	}
+	// Check that the subtitles merge the captions of both chunks.
+	if vtt := u.File("unused.vtt"); !strings.Contains(vtt, "chunk a") || !strings.Contains(vtt, "chunk b") {
+		t.Errorf("Subtitles got=%q, want the captions of both chunks", vtt)
+	}
+	if got, want := fmt.Sprint(u.Deleted()), "[b.flac]"; got != want {
+		t.Errorf("Deleted files, got=%s, want=%s", got, want)
+	}
+}
+
+func TestRunResubmitsOperationPastItsDeadline(t *testing.T) {
+	s := memstore.New()
+	key := s.Put(data.Entry{
+		Course:    data.Course{AudioLink: "http://unused"},
+		Scheduled: true,
+		Progress: data.Progress{
+			Stage:            data.StageSubmitted,
+			FLACPaths:        []string{"/gone/a.flac"},
+			OperationName:    "op-a",
+			OperationStarted: time.Now().Add(-24 * time.Hour),
+		},
+	})
+	ft := &fakeResumableTranscriber{resumeErr: &transcribe.DeadlineError{OpName: "op-a"}}
+	w := Worker{
+		picker:      memstore.NewPicker(s),
+		broker:      memstore.NewBroker(s),
+		transcriber: ft,
+		uploader:    &memstore.Uploader{},
+		indexer:     &memstore.Indexer{},
+		health:      &fakeHealthChecker{healthy: true},
+		maxRetries:  3,
+	}
+	if err := w.Run(context.Background()); err == nil {
+		t.Fatal("Run past the deadline got no error")
+	}
+	e, _ := s.Get(key)
+	if got, want := e.Progress.Stage, data.StageUploaded; got != want {
+		t.Errorf("Stage got=%s, want=%s", got, want)
+	}
+	if e.Progress.OperationName != "" || !e.Progress.OperationStarted.IsZero() {
+		t.Errorf("Operation got=%q started %s, want none", e.Progress.OperationName, e.Progress.OperationStarted)
+	}
+}
+
+func TestRunRefundsFailedCourse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	s := memstore.New()
+	key := s.Put(data.Entry{
+		Course:    data.Course{AudioLink: ts.URL, DurationSec: 3600},
+		Scheduled: true,
+	})
+	b := memstore.NewBroker(s)
+	w := Worker{
+		picker:      memstore.NewPicker(s),
+		broker:      b,
+		transcriber: &fakeTranscriber{},
+		uploader:    &memstore.Uploader{Err: fmt.Errorf("storage down")},
+		httpClient: &http.Client{
+			Timeout: time.Second * 5,
+		},
+		health:     &fakeHealthChecker{healthy: true},
+		maxRetries: 2,
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := w.Run(ctx); err == nil {
+			t.Fatalf("[%d] Run: wanted error, got nil", i)
+		}
+	}
+	e, _ := s.Get(key)
+	if !e.Failed || e.Scheduled {
+		t.Errorf("Entry failed=%t, scheduled=%t, want failed and unscheduled", e.Failed, e.Scheduled)
+	}
+	if got, want := e.FailureReason, "storage down"; got != want {
+		t.Errorf("Failure reason, got=%q, want=%q", got, want)
+	}
+	// Nothing was sent to speech recognition so the full hour is refunded.
+	history, err := b.History(ctx, time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if got, want := len(history), 1; got != want {
+		t.Fatalf("Num ledger entries, got=%d, want=%d", got, want)
+	}
+	if got, want := history[0], (money.LedgerEntry{Kind: money.KindRefund, AmountUsdCents: 144, CourseKey: key}); got.Kind != want.Kind || got.AmountUsdCents != want.AmountUsdCents || got.CourseKey != want.CourseKey {
+		t.Errorf("Ledger entry, got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestUnspentCents(t *testing.T) {
+	var tests = []struct {
+		msg      string
+		progress data.Progress
+		want     int
+	}{
+		{
+			msg:  "nothing spent",
+			want: 648,
+		}, {
+			msg:      "first chunk submitted",
+			progress: data.Progress{Stage: data.StageSubmitted},
+			want:     648 - money.DurationToUsdCents(transcribe.ChunkDuration),
+		}, {
+			msg:      "second chunk uploaded",
+			progress: data.Progress{Stage: data.StageUploaded, Chunk: 1},
+			want:     648 - money.DurationToUsdCents(transcribe.ChunkDuration),
+		}, {
+			msg:      "first chunk sent from the local disk",
+			progress: data.Progress{Stage: data.StageConvertedToFLAC, Local: true},
+			want:     648 - money.DurationToUsdCents(transcribe.ChunkDuration),
+		}, {
+			msg:      "last chunk submitted",
+			progress: data.Progress{Stage: data.StageSubmitted, Chunk: 1},
+			want:     0,
+		},
+	}
+	for _, test := range tests {
+		e := data.Entry{Course: data.Course{DurationSec: 4.5 * 3600}, Progress: test.progress}
+		if got := unspentCents(e); got != test.want {
+			t.Errorf("[%s] got=%d, want=%d", test.msg, got, test.want)
+		}
+	}
+}
+
+// concurrentTranscriber records how many conversions run at the same time.
+type concurrentTranscriber struct {
+	mu            sync.Mutex
+	running       int
+	maxRunning    int
+	transcription []transcribe.Transcription
+}
+
+func (t *concurrentTranscriber) Transcribe(ctx context.Context, path string, opts transcribe.Options) ([]transcribe.Transcription, error) {
+	return t.transcription, nil
+}
+
+func (t *concurrentTranscriber) ConvertToFLAC(ctx context.Context, tools transcribe.Tools, input string, format transcribe.Format) ([]string, error) {
+	t.mu.Lock()
+	t.running++
+	if t.running > t.maxRunning {
+		t.maxRunning = t.running
+	}
+	t.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	t.mu.Lock()
+	t.running--
+	t.mu.Unlock()
+	return []string{input}, nil
+}
+
+func TestRunConcurrentlyIsolatesFailures(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "cdf-worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	u, err := upload.NewLocalFileUploader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := memstore.New()
+	var okKeys []string
+	for i := 0; i < 4; i++ {
+		okKeys = append(okKeys, s.Put(data.Entry{
+			Course:    data.Course{AudioLink: fmt.Sprintf("%s/%d.mp3", ts.URL, i)},
+			Scheduled: true,
+		}))
+	}
+	badKey := s.Put(data.Entry{
+		Course:    data.Course{AudioLink: "http://127.0.0.1:0/unreachable.mp3"},
+		Scheduled: true,
+	})
+	ct := &concurrentTranscriber{transcription: []transcribe.Transcription{{Text: "hello"}}}
+	w := Worker{
+		picker:      memstore.NewPicker(s),
+		transcriber: ct,
+		uploader:    u,
+		indexer:     &memstore.Indexer{},
+		httpClient: &http.Client{
+			Timeout: time.Second * 5,
+		},
+		health:      &fakeHealthChecker{healthy: true},
+		maxRetries:  3,
+		parallelism: 2,
+	}
+	if err := w.Run(context.Background()); err == nil {
+		t.Error("Run: wanted an error for the unreachable course, got nil")
+	}
+	for _, k := range okKeys {
+		if e, _ := s.Get(k); !e.Converted {
+			t.Errorf("Course %s was not converted", e.AudioLink)
+		}
+	}
+	if e, _ := s.Get(badKey); e.Retries != 1 {
+		t.Errorf("Unreachable course retries, got=%d, want=1", e.Retries)
+	}
+	if got, want := ct.maxRunning, 2; got != want {
+		t.Errorf("Max concurrent conversions, got=%d, want=%d", got, want)
+	}
+}
+
+// flacHeader returns the start of a mono 16kHz FLAC stream of the given duration.
+func flacHeader(d time.Duration) []byte {
+	sampleRate := uint64(16000)
+	totalSamples := uint64(d.Seconds()) * sampleRate
+	info := make([]byte, 34)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	info[12] = byte(sampleRate << 4)
+	info[13] = 0xF0 | byte(totalSamples>>32)
+	info[14] = byte(totalSamples >> 24)
+	info[15] = byte(totalSamples >> 16)
+	info[16] = byte(totalSamples >> 8)
+	info[17] = byte(totalSamples)
+	return append([]byte("fLaC\x80\x00\x00\x22"), info...)
+}
+
+func TestRunCorrectsDuration(t *testing.T) {
+	var tests = []struct {
+		msg          string
+		real         time.Duration
+		scrapedSec   int
+		deposit      int
+		wantKind     money.Kind
+		wantAmount   int
+		wantDuration int
+	}{
+		{
+			msg:          "longer than scraped",
+			real:         2 * time.Hour,
+			scrapedSec:   3600,
+			deposit:      500,
+			wantKind:     money.KindAdjustment,
+			wantAmount:   -144,
+			wantDuration: 7200,
+		}, {
+			msg:          "longer than the balance allows",
+			real:         2 * time.Hour,
+			scrapedSec:   3600,
+			deposit:      100,
+			wantKind:     money.KindAdjustment,
+			wantAmount:   -144,
+			wantDuration: 7200,
+		}, {
+			msg:          "shorter than scraped",
+			real:         time.Hour,
+			scrapedSec:   7200,
+			deposit:      500,
+			wantKind:     money.KindAdjustment,
+			wantAmount:   144,
+			wantDuration: 3600,
+		},
+	}
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(flacHeader(test.real))
+		}))
+		defer ts.Close()
+		s := memstore.New()
+		key := s.Put(data.Entry{
+			Course:    data.Course{AudioLink: ts.URL, DurationSec: test.scrapedSec},
+			Scheduled: true,
+		})
+		b := memstore.NewBroker(s)
+		ctx := context.Background()
+		if err := b.Deposit(ctx, test.deposit, "test"); err != nil {
+			t.Fatal(err)
+		}
+		w := Worker{
+			picker:      memstore.NewPicker(s),
+			broker:      b,
+			transcriber: &fakeTranscriber{},
+			uploader:    &memstore.Uploader{},
+			indexer:     &memstore.Indexer{},
+			httpClient: &http.Client{
+				Timeout: time.Second * 5,
+			},
+			health: &fakeHealthChecker{healthy: true},
+		}
+		if err := w.Run(ctx); err != nil {
+			t.Fatalf("[%s] Run: %v", test.msg, err)
+		}
+		if e, _ := s.Get(key); e.DurationSec != test.wantDuration {
+			t.Errorf("[%s] duration got=%d, want=%d", test.msg, e.DurationSec, test.wantDuration)
+		}
+		history, err := b.History(ctx, time.Time{}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 2 {
+			t.Fatalf("[%s] num ledger entries got=%d, want=2", test.msg, len(history))
+		}
+		if got := history[1]; got.Kind != test.wantKind || got.AmountUsdCents != test.wantAmount {
+			t.Errorf("[%s] ledger entry got=%s of %d, want=%s of %d", test.msg, got.Kind, got.AmountUsdCents, test.wantKind, test.wantAmount)
+		}
 	}
 }